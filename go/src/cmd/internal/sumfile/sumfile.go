@@ -0,0 +1,200 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements the checksum lines that the skeinsum and
+// skeinmac commands print and verify.
+//
+// A line uses the format of sha256sum
+//
+//    hash  file
+//
+// or the BSD tag format
+//
+//    Skein-512-256 (file) = hash
+//
+// where the prefix of the algorithm name depends on the command. A file
+// name that contains a backslash or a newline is escaped and its line
+// starts with a backslash.
+//
+package sumfile
+
+import (
+    "crypto/skein"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+)
+
+// Maximum output size in bits
+const MaxOutputSize = 1 << 16
+
+// An Algorithm is a Skein state size and output size in bits.
+//
+type Algorithm struct {
+    StateSize  int
+    OutputSize int
+}
+
+// ParseAlgorithm parses an algorithm of the form "512-256" or "512".
+//
+func ParseAlgorithm(s string) (Algorithm, error) {
+    state, output, ok := strings.Cut(s, "-")
+    if !ok {
+        output = state
+    }
+    var a Algorithm
+    var err1, err2 error
+    a.StateSize, err1 = strconv.Atoi(state)
+    a.OutputSize, err2 = strconv.Atoi(output)
+    if err1 != nil || err2 != nil ||
+        a.StateSize != skein.Skein256 && a.StateSize != skein.Skein512 && a.StateSize != skein.Skein1024 ||
+        a.OutputSize < 8 || a.OutputSize > MaxOutputSize || a.OutputSize%8 != 0 {
+        return a, errors.New("invalid algorithm " + strconv.Quote(s))
+    }
+    return a, nil
+}
+
+// A Format is the prefix of the algorithm names in tag lines, for
+// example "Skein-" or "Skein-MAC-".
+//
+type Format string
+
+// Name returns the name of an algorithm in tag lines.
+//
+func (f Format) Name(a Algorithm) string {
+    return string(f) + strconv.Itoa(a.StateSize) + "-" + strconv.Itoa(a.OutputSize)
+}
+
+// ParseTagName parses the algorithm name of a tag line, for example
+// "Skein-512-256".
+//
+func (f Format) ParseTagName(s string) (Algorithm, bool) {
+    if !strings.HasPrefix(s, string(f)) || !strings.Contains(s[len(f):], "-") {
+        return Algorithm{}, false
+    }
+    a, err := ParseAlgorithm(s[len(f):])
+    return a, err == nil && f.Name(a) == s
+}
+
+// A Line is a parsed checksum line.
+//
+type Line struct {
+    Name string
+    Alg  Algorithm
+    Sum  []byte
+}
+
+// ParseLine parses a checksum line in tag or in sha256sum format.
+//
+// line
+//      The line without the line terminator
+// alg
+//      The algorithm of lines in sha256sum format, tag lines select
+//      their own algorithm
+//
+func (f Format) ParseLine(line string, alg Algorithm) (*Line, bool) {
+    escaped := strings.HasPrefix(line, "\\")
+    if escaped {
+        line = line[1:]
+    }
+    l := new(Line)
+    var sum string
+    if name, rest, ok := strings.Cut(line, " ("); ok && strings.HasPrefix(line, string(f)) {
+        i := strings.LastIndex(rest, ") = ")
+        if i < 0 {
+            return nil, false
+        }
+        if l.Alg, ok = f.ParseTagName(name); !ok {
+            return nil, false
+        }
+        l.Name, sum = rest[:i], rest[i+len(") = "):]
+    } else {
+        var ok bool
+        sum, l.Name, ok = strings.Cut(line, " ")
+        // The second separator character marks text or binary mode
+        if !ok || len(l.Name) < 2 || l.Name[0] != ' ' && l.Name[0] != '*' {
+            return nil, false
+        }
+        l.Name = l.Name[1:]
+        l.Alg = alg
+    }
+    var ok bool
+    if escaped {
+        if l.Name, ok = Unescape(l.Name); !ok {
+            return nil, false
+        }
+    }
+    if len(sum) != l.Alg.OutputSize/4 || l.Name == "" {
+        return nil, false
+    }
+    var err error
+    if l.Sum, err = hex.DecodeString(sum); err != nil {
+        return nil, false
+    }
+    return l, true
+}
+
+// Escape escapes backslashes and newlines in a file name. Escape reports
+// if the name contained such characters.
+//
+func Escape(name string) (string, bool) {
+    if !strings.ContainsAny(name, "\\\n") {
+        return name, false
+    }
+    return strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name), true
+}
+
+// Unescape reverses Escape, it reports false for an invalid escape
+// sequence.
+//
+func Unescape(name string) (string, bool) {
+    var b strings.Builder
+    for i := 0; i < len(name); i++ {
+        if name[i] != '\\' {
+            b.WriteByte(name[i])
+            continue
+        }
+        if i++; i == len(name) {
+            return "", false
+        }
+        switch name[i] {
+        case '\\':
+            b.WriteByte('\\')
+        case 'n':
+            b.WriteByte('\n')
+        default:
+            return "", false
+        }
+    }
+    return b.String(), true
+}
+
+// Warning prints the warning of a command about n problems, one and many
+// describe a single and several problems.
+//
+func Warning(w io.Writer, cmd string, n int, one, many string) {
+    switch {
+    case n == 1:
+        fmt.Fprintf(w, "%s: WARNING: 1 %s\n", cmd, one)
+    case n > 1:
+        fmt.Fprintf(w, "%s: WARNING: %d %s\n", cmd, n, many)
+    }
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package sumfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseAlgorithm(t *testing.T) {
+	valid := map[string]Algorithm{
+		"512-256":   {512, 256},
+		"256":       {256, 256},
+		"1024-1024": {1024, 1024},
+		"256-8":     {256, 8},
+		"512-2048":  {512, 2048},
+	}
+	for s, want := range valid {
+		if a, err := ParseAlgorithm(s); err != nil || a != want {
+			t.Errorf("%q: got %v, %v, want %v", s, a, err, want)
+		}
+	}
+	for _, s := range []string{"", "384", "512-", "-256", "512-255", "512-0", "512-256-1", "x"} {
+		if _, err := ParseAlgorithm(s); err == nil {
+			t.Errorf("%q: accepted", s)
+		}
+	}
+}
+
+func TestParseTagName(t *testing.T) {
+	if a, ok := Format("Skein-").ParseTagName("Skein-1024-384"); !ok || a != (Algorithm{1024, 384}) {
+		t.Errorf("tag name: got %v, %v", a, ok)
+	}
+	if a, ok := Format("Skein-MAC-").ParseTagName("Skein-MAC-256-128"); !ok || a != (Algorithm{256, 128}) {
+		t.Errorf("MAC tag name: got %v, %v", a, ok)
+	}
+	for _, s := range []string{"Skein-512", "SKEIN-512-256", "Skein-512-0256", "Skein-MAC-512-256"} {
+		if _, ok := Format("Skein-").ParseTagName(s); ok {
+			t.Errorf("tag name %q: accepted", s)
+		}
+	}
+	if _, ok := Format("Skein-MAC-").ParseTagName("Skein-512-256"); ok {
+		t.Errorf("MAC tag name: accepted a checksum name")
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	f := Format("Skein-")
+	alg := Algorithm{256, 16}
+	valid := map[string]Line{
+		"abcd  file":                     {"file", alg, []byte{0xab, 0xcd}},
+		"abcd *file":                     {"file", alg, []byte{0xab, 0xcd}},
+		"abcd  two  spaces":              {"two  spaces", alg, []byte{0xab, 0xcd}},
+		"Skein-512-8 (a (b) = c) = 0f":   {"a (b) = c", Algorithm{512, 8}, []byte{0x0f}},
+		"\\abcd  new\\nline\\\\back":     {"new\nline\\back", alg, []byte{0xab, 0xcd}},
+		"\\Skein-512-8 (x\\\\y) = ff":    {"x\\y", Algorithm{512, 8}, []byte{0xff}},
+		"Skein-1024-24 (-) = 010203":     {"-", Algorithm{1024, 24}, []byte{1, 2, 3}},
+		"Skein-MAC-512-8 (x) = ff  file": {"file", alg, nil},
+	}
+	for s, want := range valid {
+		l, ok := f.ParseLine(s, alg)
+		if want.Sum == nil {
+			if ok {
+				t.Errorf("%q: accepted", s)
+			}
+			continue
+		}
+		if !ok || l.Name != want.Name || l.Alg != want.Alg || !bytes.Equal(l.Sum, want.Sum) {
+			t.Errorf("%q: got %v, %v, want %v", s, l, ok, want)
+		}
+	}
+	for _, s := range []string{
+		"", "abcd", "abcd file", "abcd  ", "abc  file", "abcde  file", "xyzw  file",
+		"Skein-512-8 (x) = fff", "Skein-512-8 (x) ff", "Skein-512 (x) = ff",
+		"Skein-512-8 () = ff", "\\abcd  x\\t",
+	} {
+		if l, ok := f.ParseLine(s, alg); ok {
+			t.Errorf("%q: accepted as %v", s, l)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	for _, name := range []string{"plain", "new\nline\\back", "\\", "\n\n"} {
+		shown, escaped := Escape(name)
+		if escaped != strings.ContainsAny(name, "\\\n") || strings.Contains(shown, "\n") {
+			t.Errorf("%q: got %q, %v", name, shown, escaped)
+		}
+		if got, ok := Unescape(shown); !ok || got != name {
+			t.Errorf("%q: unescape got %q, %v", name, got, ok)
+		}
+	}
+	for _, s := range []string{"x\\t", "x\\", "\\\\\\"} {
+		if _, ok := Unescape(s); ok {
+			t.Errorf("unescape %q: accepted", s)
+		}
+	}
+}
+
+func TestWarning(t *testing.T) {
+	var b bytes.Buffer
+	for n := 0; n < 3; n++ {
+		Warning(&b, "cmd", n, "line is bad", "lines are bad")
+	}
+	if want := "cmd: WARNING: 1 line is bad\ncmd: WARNING: 2 lines are bad\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+package main
+
+import (
+    "bufio"
+    "cmd/internal/sumfile"
+    "crypto/skein"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+const format = sumfile.Format("Skein-MAC-")
+
+// Compute the MAC of a file, the name "-" selects standard input.
+//
+func (c *command) mac(name string, alg sumfile.Algorithm) (*skein.SkeinMac, error) {
+    var r io.Reader = c.stdin
+    if name != "-" {
+        fd, err := os.Open(name)
+        if err != nil {
+            return nil, err
+        }
+        defer fd.Close()
+        r = fd
+    }
+    m, err := skein.NewMac(alg.StateSize, alg.OutputSize, c.key)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := io.Copy(m, r); err != nil {
+        return nil, err
+    }
+    return m, nil
+}
+
+// Print the tags of the files, return the exit status.
+//
+func (c *command) sum(files []string) int {
+    status := 0
+    for _, f := range files {
+        m, err := c.mac(f, c.alg)
+        if err != nil {
+            c.error(err)
+            status = 1
+            continue
+        }
+        tag := m.DoFinal()
+        shown, escaped := sumfile.Escape(f)
+        if escaped {
+            io.WriteString(c.stdout, "\\")
+        }
+        if c.tag {
+            fmt.Fprintf(c.stdout, "%s (%s) = %x\n", format.Name(c.alg), shown, tag)
+        } else {
+            fmt.Fprintf(c.stdout, "%x  %s\n", tag, shown)
+        }
+    }
+    return status
+}
+
+// Verify the tags listed in the manifests, return the exit status.
+//
+func (c *command) verify(manifests []string) int {
+    status := 0
+    for _, manifest := range manifests {
+        if !c.verifyManifest(manifest) {
+            status = 1
+        }
+    }
+    return status
+}
+
+// Verify the tags of one manifest, report if all checks passed.
+//
+func (c *command) verifyManifest(manifest string) bool {
+    var r io.Reader = c.stdin
+    if manifest != "-" {
+        fd, err := os.Open(manifest)
+        if err != nil {
+            c.error(err)
+            return false
+        }
+        defer fd.Close()
+        r = fd
+    }
+
+    invalid, failed, unreadable, verified := 0, 0, 0, 0
+    br := bufio.NewReader(r)
+    for n := 1; ; n++ {
+        line, err := br.ReadString('\n')
+        if err != nil && err != io.EOF {
+            c.error(err)
+            return false
+        }
+        if line == "" && err == io.EOF {
+            break
+        }
+        line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+        if e, ok := format.ParseLine(line, c.alg); ok {
+            switch c.check(e) {
+            case nil:
+                verified++
+            case errMismatch:
+                failed++
+            default:
+                unreadable++
+            }
+        } else {
+            invalid++
+            if !c.status {
+                fmt.Fprintf(c.stderr, "%s: %s: %d: improperly formatted Skein-MAC line\n", name, manifest, n)
+            }
+        }
+        if err == io.EOF {
+            break
+        }
+    }
+    if invalid+failed+unreadable+verified == 0 {
+        fmt.Fprintf(c.stderr, "%s: %s: no properly formatted Skein-MAC lines found\n", name, manifest)
+        return false
+    }
+    if !c.status {
+        sumfile.Warning(c.stderr, name, invalid, "line is improperly formatted", "lines are improperly formatted")
+        sumfile.Warning(c.stderr, name, unreadable, "listed file could not be read", "listed files could not be read")
+        sumfile.Warning(c.stderr, name, failed, "computed tag did NOT match", "computed tags did NOT match")
+    }
+    return invalid == 0 && failed == 0 && unreadable == 0
+}
+
+var errMismatch = errors.New("tag mismatch")
+
+// Verify the tag of a manifest entry and print the result.
+//
+func (c *command) check(e *sumfile.Line) error {
+    shown, escaped := sumfile.Escape(e.Name)
+    if escaped {
+        shown = "\\" + shown
+    }
+    m, err := c.mac(e.Name, e.Alg)
+    if err != nil {
+        c.error(err)
+        if !c.status {
+            fmt.Fprintf(c.stdout, "%s: FAILED open or read\n", shown)
+        }
+        return err
+    }
+    if !m.Verify(e.Sum) {
+        if !c.status {
+            fmt.Fprintf(c.stdout, "%s: FAILED\n", shown)
+        }
+        return errMismatch
+    }
+    if !c.status && !c.quiet {
+        fmt.Fprintf(c.stdout, "%s: OK\n", shown)
+    }
+    return nil
+}
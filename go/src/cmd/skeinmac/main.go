@@ -0,0 +1,188 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// Skeinmac computes or verifies Skein-MAC tags of files.
+//
+// Usage:
+//
+//    skeinmac -key hex | -keyenv name | -keyfile file [flags] [file ...]
+//
+// With no file, or when file is -, skeinmac reads standard input. The
+// output lines use the format of skeinsum
+//
+//    tag  file
+//
+// or, with -tag, the BSD tag format
+//
+//    Skein-MAC-512-256 (file) = tag
+//
+// A file name that contains a backslash or a newline is escaped and its
+// line starts with a backslash. Exactly one of the key flags selects the
+// MAC key:
+//
+//    -key hex       The key in hexadecimal.
+//    -keyenv name   The environment variable that holds the key in
+//                   hexadecimal.
+//    -keyfile file  The file that holds the raw key bytes.
+//
+// A key given with -key appears in the process list, for example of ps,
+// and in the shell history. Use -keyenv or -keyfile for secret keys.
+//
+// The other flags are:
+//
+//    -a state-output
+//          The Skein state size and output size in bits, for example
+//          512-256. A single number selects equal state and output
+//          sizes. Default 512-256.
+//    -tag  Print BSD tag lines.
+//    -c    Read tag lines from the manifest files and verify them. Tag
+//          lines select their own algorithm, the other lines use -a.
+//    -quiet
+//          Verify mode: do not print OK for each verified file.
+//    -status
+//          Verify mode: print nothing, the exit status shows success.
+//
+// Skeinmac compares tags in constant time. A manifest line that is not
+// properly formatted fails the verification.
+//
+// The exit status is 0 on success, 1 if a file could not be read, a tag
+// did not match or a manifest line is not properly formatted, and 2 for
+// invalid flags or an invalid key.
+//
+package main
+
+import (
+    "cmd/internal/sumfile"
+    "encoding/hex"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+const name = "skeinmac"
+
+// A command holds the flags, the key and the standard files of one run.
+//
+type command struct {
+    alg    sumfile.Algorithm
+    key    []byte
+    tag    bool
+    quiet  bool
+    status bool
+
+    stdin  io.Reader
+    stdout io.Writer
+    stderr io.Writer
+}
+
+func main() {
+    os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Run skeinmac with the arguments args and return the exit status.
+//
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+    c := &command{stdin: stdin, stdout: stdout, stderr: stderr}
+    fs := flag.NewFlagSet(name, flag.ContinueOnError)
+    fs.SetOutput(stderr)
+    fs.Usage = func() {
+        fmt.Fprintf(stderr, "usage: %s -key hex | -keyenv name | -keyfile file [flags] [file ...]\n", name)
+        fs.PrintDefaults()
+    }
+    a := fs.String("a", "512-256", "Skein `state-output` size in bits")
+    keyHex := fs.String("key", "", "the key in `hex`adecimal, visible in ps and the shell history, prefer -keyenv or -keyfile")
+    keyEnv := fs.String("keyenv", "", "environment variable `name` of the hexadecimal key")
+    keyFile := fs.String("keyfile", "", "`file` with the raw key bytes")
+    check := fs.Bool("c", false, "read tags from the manifest files and verify them")
+    fs.BoolVar(&c.tag, "tag", false, "print BSD tag lines")
+    fs.BoolVar(&c.quiet, "quiet", false, "verify mode: do not print OK for each verified file")
+    fs.BoolVar(&c.status, "status", false, "verify mode: print nothing, the exit status shows success")
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+
+    var err error
+    if c.alg, err = sumfile.ParseAlgorithm(*a); err != nil {
+        return c.usage(err.Error())
+    }
+    if c.key, err = loadKey(*keyHex, *keyEnv, *keyFile); err != nil {
+        return c.usage(err.Error())
+    }
+    defer clear(c.key)
+    if *check && c.tag {
+        return c.usage("the -tag flag is meaningless when verifying tags")
+    }
+    if !*check && (c.quiet || c.status) {
+        return c.usage("the -quiet and -status flags are meaningful only when verifying tags")
+    }
+    files := fs.Args()
+    if len(files) == 0 {
+        files = []string{"-"}
+    }
+    if *check {
+        return c.verify(files)
+    }
+    return c.sum(files)
+}
+
+// Load the key from exactly one of the sources.
+//
+func loadKey(keyHex, keyEnv, keyFile string) ([]byte, error) {
+    n := 0
+    for _, s := range []string{keyHex, keyEnv, keyFile} {
+        if s != "" {
+            n++
+        }
+    }
+    if n != 1 {
+        return nil, errors.New("exactly one of -key, -keyenv and -keyfile is required")
+    }
+    var key []byte
+    var err error
+    switch {
+    case keyHex != "":
+        key, err = hex.DecodeString(keyHex)
+    case keyEnv != "":
+        value, ok := os.LookupEnv(keyEnv)
+        if !ok {
+            return nil, errors.New("environment variable " + keyEnv + " is not set")
+        }
+        key, err = hex.DecodeString(strings.TrimSpace(value))
+    default:
+        key, err = os.ReadFile(keyFile)
+    }
+    if err != nil {
+        return nil, errors.New("invalid key: " + err.Error())
+    }
+    if len(key) == 0 {
+        return nil, errors.New("invalid key: empty key")
+    }
+    return key, nil
+}
+
+func (c *command) usage(msg string) int {
+    fmt.Fprintf(c.stderr, "%s: %s\n", name, msg)
+    return 2
+}
+
+func (c *command) error(err error) {
+    fmt.Fprintf(c.stderr, "%s: %v\n", name, err)
+}
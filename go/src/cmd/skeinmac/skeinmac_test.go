@@ -0,0 +1,169 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package main
+
+import (
+	"bytes"
+	"cmd/internal/sumfile"
+	"crypto/skein"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// Frozen tags of the message "abc" with testKey
+var macVectors = []struct {
+	alg, tag string
+}{
+	{"512-256", "c73994410120d365c485599bf65c69e93a4049000442a4ca935160b314b46465"},
+	{"1024-512", "98bd5b2835340d07d64eb3d2b6ed5f49709c8d9d5f3f0529865d12ce745179b70fa11ade3b06722c07e0f785d1aec299a0dc1064e4b57fbdb6ca312223d9afed"},
+}
+
+// Run skeinmac, return the exit status, standard output and standard error.
+func runMac(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	status := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func writeFile(t *testing.T, name, data string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMac(t *testing.T) {
+	for _, v := range macVectors {
+		status, out, _ := runMac(t, "abc", "-key", testKey, "-a", v.alg)
+		if status != 0 || out != v.tag+"  -\n" {
+			t.Errorf("%s: got %d, %q, want %s", v.alg, status, out, v.tag)
+		}
+	}
+	// The tags are Skein-MAC tags at the selected sizes
+	key, _ := hex.DecodeString(testKey)
+	for _, a := range []string{"256-128", "512-512", "1024-1024", "256-2048"} {
+		alg, err := sumfile.ParseAlgorithm(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := skein.NewMac(alg.StateSize, alg.OutputSize, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Update([]byte("message"))
+		want := hex.EncodeToString(m.DoFinal())
+		status, out, _ := runMac(t, "message", "-key", testKey, "-a", a, "-tag")
+		if status != 0 || out != "Skein-MAC-"+a+" (-) = "+want+"\n" {
+			t.Errorf("%s: got %d, %q, want %s", a, status, out, want)
+		}
+	}
+}
+
+func TestKeySources(t *testing.T) {
+	t.Chdir(t.TempDir())
+	key, _ := hex.DecodeString(testKey)
+	writeFile(t, "keyfile", string(key))
+	t.Setenv("SKEINMAC_TEST_KEY", strings.ToUpper(testKey)+"\n")
+	want := macVectors[0].tag + "  -\n"
+	for _, args := range [][]string{
+		{"-key", testKey},
+		{"-keyenv", "SKEINMAC_TEST_KEY"},
+		{"-keyfile", "keyfile"},
+	} {
+		if status, out, _ := runMac(t, "abc", args...); status != 0 || out != want {
+			t.Errorf("%v: got %d, %q", args, status, out)
+		}
+	}
+
+	writeFile(t, "empty", "")
+	for _, args := range [][]string{
+		{},
+		{"-key", testKey, "-keyfile", "keyfile"},
+		{"-key", "xyz"},
+		{"-keyenv", "SKEINMAC_TEST_UNSET"},
+		{"-keyfile", "missing"},
+		{"-keyfile", "empty"},
+		{"-key", testKey, "-a", "512-7"},
+		{"-key", testKey, "-c", "-tag"},
+		{"-key", testKey, "-quiet"},
+	} {
+		if status, _, _ := runMac(t, "abc", args...); status != 2 {
+			t.Errorf("%v: got status %d, want 2", args, status)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFile(t, "a", "alpha")
+	writeFile(t, "b", "beta")
+	writeFile(t, "new\nline", "gamma")
+	_, manifest, _ := runMac(t, "", "-key", testKey, "a", "b", "new\nline")
+	_, tags, _ := runMac(t, "", "-key", testKey, "-a", "1024-384", "-tag", "a")
+	writeFile(t, "manifest", manifest+tags)
+
+	status, out, errOut := runMac(t, "", "-key", testKey, "-c", "manifest")
+	if status != 0 || out != "a: OK\nb: OK\n\\new\\nline: OK\na: OK\n" || errOut != "" {
+		t.Errorf("verify: got %d, %q, %q", status, out, errOut)
+	}
+	if status, out, _ := runMac(t, manifest, "-key", testKey, "-c", "-quiet"); status != 0 || out != "" {
+		t.Errorf("quiet: got %d, %q", status, out)
+	}
+
+	// A different key fails every line
+	status, out, errOut = runMac(t, "", "-key", testKey[2:], "-c", "manifest")
+	if status != 1 || strings.Count(out, "FAILED\n") != 4 ||
+		errOut != "skeinmac: WARNING: 4 computed tags did NOT match\n" {
+		t.Errorf("wrong key: got %d, %q, %q", status, out, errOut)
+	}
+
+	writeFile(t, "b", "BETA")
+	status, out, errOut = runMac(t, "", "-key", testKey, "-c", "-status", "manifest")
+	if status != 1 || out != "" || errOut != "" {
+		t.Errorf("modified file: got %d, %q, %q", status, out, errOut)
+	}
+	writeFile(t, "b", "beta")
+
+	os.Remove("a")
+	status, out, _ = runMac(t, "", "-key", testKey, "-c", "manifest")
+	if status != 1 || !strings.HasPrefix(out, "a: FAILED open or read\n") {
+		t.Errorf("missing file: got %d, %q", status, out)
+	}
+	writeFile(t, "a", "alpha")
+
+	// Improperly formatted lines fail the verification
+	for _, bad := range []string{
+		manifest + "garbage\n",
+		manifest + "\n",
+		manifest + strings.Replace(tags, "Skein-MAC", "Skein", 1),
+		manifest + tags[:len(tags)-2] + "\n",
+	} {
+		status, _, errOut := runMac(t, bad, "-key", testKey, "-c")
+		if status != 1 || !strings.Contains(errOut, "improperly formatted") {
+			t.Errorf("%q: got %d, %q", bad, status, errOut)
+		}
+	}
+	if status, _, _ := runMac(t, "", "-key", testKey, "-c"); status != 1 {
+		t.Errorf("empty manifest: got status %d", status)
+	}
+}
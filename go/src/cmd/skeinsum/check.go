@@ -0,0 +1,137 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "cmd/internal/sumfile"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "strings"
+)
+
+// Parse a checksum line in tag or in sha256sum format.
+//
+func (c *command) parseLine(line string) (*file, bool) {
+    l, ok := format.ParseLine(line, c.alg)
+    if !ok {
+        return nil, false
+    }
+    return &file{name: l.Name, alg: l.Alg, want: l.Sum}, true
+}
+
+// Check the checksums listed in the files, return the exit status.
+//
+func (c *command) check(lists []string) int {
+    status := 0
+    for _, list := range lists {
+        if !c.checkList(list) {
+            status = 1
+        }
+    }
+    return status
+}
+
+// Check the checksums of one list, report if all checks passed.
+//
+func (c *command) checkList(list string) bool {
+    var r io.Reader = c.stdin
+    if list != "-" {
+        fd, err := os.Open(list)
+        if err != nil {
+            c.error(err)
+            return false
+        }
+        defer fd.Close()
+        r = fd
+    }
+
+    var files []*file
+    invalid := 0
+    br := bufio.NewReader(r)
+    for n := 1; ; n++ {
+        line, err := br.ReadString('\n')
+        if err != nil && err != io.EOF {
+            c.error(err)
+            return false
+        }
+        if line == "" && err == io.EOF {
+            break
+        }
+        line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+        if f, ok := c.parseLine(line); ok {
+            files = append(files, f)
+        } else {
+            invalid++
+            if c.warn {
+                fmt.Fprintf(c.stderr, "%s: %s: %d: improperly formatted Skein checksum line\n", name, list, n)
+            }
+        }
+        if err == io.EOF {
+            break
+        }
+    }
+    if len(files) == 0 {
+        fmt.Fprintf(c.stderr, "%s: %s: no properly formatted Skein checksum lines found\n", name, list)
+        return false
+    }
+
+    failed, unreadable, verified := 0, 0, 0
+    c.hashAll(files, func(f *file, r result) {
+        shown, escaped := sumfile.Escape(f.name)
+        if escaped {
+            shown = "\\" + shown
+        }
+        if r.err != nil {
+            if c.ignoreMissing && errors.Is(r.err, fs.ErrNotExist) {
+                return
+            }
+            unreadable++
+            c.error(r.err)
+            if !c.status {
+                fmt.Fprintf(c.stdout, "%s: FAILED open or read\n", shown)
+            }
+            return
+        }
+        verified++
+        if !bytes.Equal(r.sum, f.want) {
+            failed++
+            if !c.status {
+                fmt.Fprintf(c.stdout, "%s: FAILED\n", shown)
+            }
+        } else if !c.status && !c.quiet {
+            fmt.Fprintf(c.stdout, "%s: OK\n", shown)
+        }
+    })
+
+    if !c.status {
+        sumfile.Warning(c.stderr, name, invalid, "line is improperly formatted", "lines are improperly formatted")
+        sumfile.Warning(c.stderr, name, unreadable, "listed file could not be read", "listed files could not be read")
+        sumfile.Warning(c.stderr, name, failed, "computed checksum did NOT match", "computed checksums did NOT match")
+    }
+    if c.ignoreMissing && verified == 0 && unreadable == 0 {
+        fmt.Fprintf(c.stderr, "%s: %s: no file was verified\n", name, list)
+        return false
+    }
+    return failed == 0 && unreadable == 0 && (!c.strict || invalid == 0)
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// Skeinsum prints or checks Skein checksums.
+//
+// Usage:
+//
+//    skeinsum [flags] [file ...]
+//
+// With no file, or when file is -, skeinsum reads standard input. The
+// output lines use the format of sha256sum
+//
+//    hash  file
+//
+// or, with -tag, the BSD tag format
+//
+//    Skein-512-256 (file) = hash
+//
+// A file name that contains a backslash or a newline is escaped and its
+// line starts with a backslash. The flags are:
+//
+//    -a state-output
+//          The Skein state size and output size in bits, for example
+//          512-256. A single number selects equal state and output
+//          sizes. Default 512-512.
+//    -tag  Print BSD tag lines.
+//    -r    Hash the files in directories recursively.
+//    -j n  Hash n files in parallel, default the number of CPUs.
+//    -c    Read checksum lines from the files and check them. Tag lines
+//          select their own algorithm, the other lines use -a.
+//
+// Flags of check mode:
+//
+//    -ignore-missing  Do not fail or report status for missing files.
+//    -quiet           Do not print OK for each verified file.
+//    -status          Print nothing, the exit status shows success.
+//    -strict          Exit non-zero for improperly formatted lines.
+//    -warn            Warn about improperly formatted lines.
+//
+// The exit status is 0 on success, 1 if a file could not be read or a
+// checksum did not match, and 2 for invalid flags.
+//
+package main
+
+import (
+    "cmd/internal/sumfile"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "runtime"
+)
+
+const name = "skeinsum"
+
+// A command holds the flags and the standard files of one run.
+//
+type command struct {
+    alg           sumfile.Algorithm
+    tag           bool
+    recursive     bool
+    jobs          int
+    ignoreMissing bool
+    quiet         bool
+    status        bool
+    strict        bool
+    warn          bool
+
+    stdin  io.Reader
+    stdout io.Writer
+    stderr io.Writer
+}
+
+func main() {
+    os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Run skeinsum with the arguments args and return the exit status.
+//
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+    c := &command{stdin: stdin, stdout: stdout, stderr: stderr}
+    fs := flag.NewFlagSet(name, flag.ContinueOnError)
+    fs.SetOutput(stderr)
+    fs.Usage = func() {
+        fmt.Fprintf(stderr, "usage: %s [flags] [file ...]\n", name)
+        fs.PrintDefaults()
+    }
+    a := fs.String("a", "512-512", "Skein `state-output` size in bits")
+    check := fs.Bool("c", false, "read checksums from the files and check them")
+    fs.BoolVar(&c.tag, "tag", false, "print BSD tag lines")
+    fs.BoolVar(&c.recursive, "r", false, "hash the files in directories recursively")
+    fs.IntVar(&c.jobs, "j", runtime.NumCPU(), "number of files hashed in parallel")
+    fs.BoolVar(&c.ignoreMissing, "ignore-missing", false, "check mode: do not fail or report status for missing files")
+    fs.BoolVar(&c.quiet, "quiet", false, "check mode: do not print OK for each verified file")
+    fs.BoolVar(&c.status, "status", false, "check mode: print nothing, the exit status shows success")
+    fs.BoolVar(&c.strict, "strict", false, "check mode: exit non-zero for improperly formatted lines")
+    fs.BoolVar(&c.warn, "warn", false, "check mode: warn about improperly formatted lines")
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+
+    var err error
+    if c.alg, err = sumfile.ParseAlgorithm(*a); err != nil {
+        return c.usage(err.Error())
+    }
+    if c.jobs < 1 {
+        return c.usage("invalid number of jobs " + fmt.Sprint(c.jobs))
+    }
+    if *check && (c.tag || c.recursive) {
+        return c.usage("the -tag and -r flags are meaningless when checking checksums")
+    }
+    if !*check && (c.ignoreMissing || c.quiet || c.status || c.strict || c.warn) {
+        return c.usage("the check mode flags are meaningful only when checking checksums")
+    }
+    files := fs.Args()
+    if len(files) == 0 {
+        files = []string{"-"}
+    }
+    if *check {
+        return c.check(files)
+    }
+    return c.sum(files)
+}
+
+func (c *command) usage(msg string) int {
+    fmt.Fprintf(c.stderr, "%s: %s\n", name, msg)
+    return 2
+}
+
+func (c *command) error(err error) {
+    fmt.Fprintf(c.stderr, "%s: %v\n", name, err)
+}
@@ -0,0 +1,202 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Skein-512-512 of the empty message, from the Skein reference
+const empty512 = "bc5b4c50925519c290cc634277ae3d6257212395cba733bbad37a4af0fa06af41fca7903d06564fea7a2d3730dbdb80c1f85562dfcc070334ea4d1d9e72cba7a"
+
+// Run skeinsum, return the exit status, standard output and standard error.
+func runSum(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	status := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func writeFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSum(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, map[string]string{"empty": "", "abc": "abc"})
+
+	status, out, _ := runSum(t, "")
+	if status != 0 || out != empty512+"  -\n" {
+		t.Errorf("stdin: got %d, %q", status, out)
+	}
+	status, out, _ = runSum(t, "abc", "-tag", "empty", "-")
+	if want := "Skein-512-512 (empty) = " + empty512 + "\n"; status != 0 || !strings.HasPrefix(out, want) {
+		t.Errorf("tag: got %d, %q, want prefix %q", status, out, want)
+	}
+	_, stdinSum, _ := runSum(t, "abc", "-a", "256-128")
+	_, fileSum, _ := runSum(t, "", "-a", "256-128", "abc")
+	if sum := strings.TrimSuffix(stdinSum, "  -\n"); len(sum) != 32 || sum != strings.TrimSuffix(fileSum, "  abc\n") {
+		t.Errorf("got %q and %q", stdinSum, fileSum)
+	}
+
+	status, out, errOut := runSum(t, "", "empty", "missing", "abc")
+	if status != 1 || strings.Count(out, "\n") != 2 || !strings.Contains(errOut, "missing") {
+		t.Errorf("missing file: got %d, %q, %q", status, out, errOut)
+	}
+	for _, args := range [][]string{{"-a", "384"}, {"-j", "0"}, {"-c", "-tag"}, {"-status"}, {"-x"}} {
+		if status, _, _ := runSum(t, "", args...); status != 2 {
+			t.Errorf("%v: got status %d, want 2", args, status)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, map[string]string{"a": "alpha", "b": "beta", "c": "gamma"})
+	_, list, _ := runSum(t, "", "-a", "512-256", "a", "b", "c")
+	writeFiles(t, map[string]string{"list": list})
+
+	status, out, errOut := runSum(t, "", "-a", "512-256", "-c", "list")
+	if status != 0 || out != "a: OK\nb: OK\nc: OK\n" || errOut != "" {
+		t.Errorf("check: got %d, %q, %q", status, out, errOut)
+	}
+	// The default algorithm does not match the checksum length
+	if status, _, _ := runSum(t, "", "-c", "list"); status != 1 {
+		t.Errorf("wrong algorithm: got status %d", status)
+	}
+	status, out, _ = runSum(t, list, "-a", "512-256", "-c", "-quiet")
+	if status != 0 || out != "" {
+		t.Errorf("quiet: got %d, %q", status, out)
+	}
+
+	writeFiles(t, map[string]string{"b": "BETA"})
+	status, out, errOut = runSum(t, "", "-a", "512-256", "-c", "list")
+	if status != 1 || out != "a: OK\nb: FAILED\nc: OK\n" ||
+		errOut != "skeinsum: WARNING: 1 computed checksum did NOT match\n" {
+		t.Errorf("mismatch: got %d, %q, %q", status, out, errOut)
+	}
+	status, out, errOut = runSum(t, "", "-a", "512-256", "-c", "-status", "list")
+	if status != 1 || out != "" || errOut != "" {
+		t.Errorf("status: got %d, %q, %q", status, out, errOut)
+	}
+
+	writeFiles(t, map[string]string{"b": "beta"})
+	os.Remove("c")
+	status, out, errOut = runSum(t, "", "-a", "512-256", "-c", "list")
+	if status != 1 || out != "a: OK\nb: OK\nc: FAILED open or read\n" ||
+		!strings.HasSuffix(errOut, "skeinsum: WARNING: 1 listed file could not be read\n") {
+		t.Errorf("missing: got %d, %q, %q", status, out, errOut)
+	}
+	status, out, errOut = runSum(t, "", "-a", "512-256", "-c", "-ignore-missing", "list")
+	if status != 0 || out != "a: OK\nb: OK\n" || errOut != "" {
+		t.Errorf("ignore missing: got %d, %q, %q", status, out, errOut)
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, map[string]string{"a": "alpha", "b": "beta"})
+	_, tag256, _ := runSum(t, "", "-tag", "-a", "256-256", "a")
+	_, tag1024, _ := runSum(t, "", "-tag", "-a", "1024-384", "b")
+	_, gnu, _ := runSum(t, "", "b")
+	binary := strings.Replace(gnu, "  ", " *", 1)
+	list := tag256 + tag1024 + "garbage\n" + binary + strings.TrimSuffix(gnu, "\n") + "\r\n"
+
+	status, out, errOut := runSum(t, list, "-c", "-warn")
+	if status != 0 || out != "a: OK\nb: OK\nb: OK\nb: OK\n" ||
+		errOut != "skeinsum: -: 3: improperly formatted Skein checksum line\n"+
+			"skeinsum: WARNING: 1 line is improperly formatted\n" {
+		t.Errorf("mixed list: got %d, %q, %q", status, out, errOut)
+	}
+	if status, _, _ := runSum(t, list, "-c", "-strict"); status != 1 {
+		t.Errorf("strict: got status %d", status)
+	}
+	status, _, errOut = runSum(t, "garbage\n\n", "-c")
+	if status != 1 || errOut != "skeinsum: -: no properly formatted Skein checksum lines found\n" {
+		t.Errorf("no lines: got %d, %q", status, errOut)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	t.Chdir(t.TempDir())
+	name := "new\nline\\back"
+	writeFiles(t, map[string]string{name: "data"})
+	for _, flags := range [][]string{nil, {"-tag"}} {
+		status, list, _ := runSum(t, "", append(flags, name)...)
+		if status != 0 || !strings.HasPrefix(list, "\\") || !strings.Contains(list, "new\\nline\\\\back") {
+			t.Fatalf("%v: got %d, %q", flags, status, list)
+		}
+		status, out, _ := runSum(t, list, "-c")
+		if status != 0 || out != "\\new\\nline\\\\back: OK\n" {
+			t.Errorf("%v: check got %d, %q", flags, status, out)
+		}
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, map[string]string{
+		"top":           "1",
+		"dir/b":         "2",
+		"dir/a":         "3",
+		"dir/sub/c":     "4",
+		"dir/sub/d/e/f": "5",
+	})
+	status, out, _ := runSum(t, "", "-r", "dir", "top")
+	var names []string
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		names = append(names, line[strings.Index(line, "  ")+2:])
+	}
+	want := "dir/a dir/b dir/sub/c dir/sub/d/e/f top"
+	if status != 0 || strings.Join(names, " ") != want {
+		t.Errorf("got %d, %q, want %q", status, names, want)
+	}
+	if status, _, errOut := runSum(t, "", "dir"); status != 1 || errOut == "" {
+		t.Errorf("directory without -r: got %d, %q", status, errOut)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	t.Chdir(t.TempDir())
+	files := make(map[string]string)
+	var args []string
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("f%02d", i)
+		files[name] = strings.Repeat(name, i*100)
+		args = append(args, name)
+	}
+	writeFiles(t, files)
+	_, serial, _ := runSum(t, "", append([]string{"-j", "1"}, args...)...)
+	_, parallel, _ := runSum(t, "", append([]string{"-j", "8"}, args...)...)
+	if serial != parallel || strings.Count(serial, "\n") != len(args) {
+		t.Errorf("parallel output differs:\n%s\n%s", serial, parallel)
+	}
+}
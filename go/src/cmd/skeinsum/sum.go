@@ -0,0 +1,150 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+package main
+
+import (
+    "cmd/internal/sumfile"
+    "crypto/skein"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+)
+
+const format = sumfile.Format("Skein-")
+
+// A file to hash. If err is not nil the file was not found while
+// walking a directory.
+//
+type file struct {
+    name string
+    alg  sumfile.Algorithm
+    err  error
+    want []byte // expected checksum in check mode
+}
+
+type result struct {
+    sum []byte
+    err error
+}
+
+// Compute the checksum of a file, the name "-" selects standard input.
+//
+func (c *command) hashFile(f *file) ([]byte, error) {
+    if f.err != nil {
+        return nil, f.err
+    }
+    var r io.Reader = c.stdin
+    if f.name != "-" {
+        fd, err := os.Open(f.name)
+        if err != nil {
+            return nil, err
+        }
+        defer fd.Close()
+        r = fd
+    }
+    h, err := skein.New(f.alg.StateSize, f.alg.OutputSize)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := io.Copy(h, r); err != nil {
+        return nil, err
+    }
+    return h.DoFinal(), nil
+}
+
+// Hash the files with c.jobs workers in parallel and call done for each
+// file in the order of files.
+//
+func (c *command) hashAll(files []*file, done func(f *file, r result)) {
+    results := make([]chan result, len(files))
+    for i := range results {
+        results[i] = make(chan result, 1)
+    }
+    next := make(chan int)
+    for w := 0; w < c.jobs && w < len(files); w++ {
+        go func() {
+            for i := range next {
+                sum, err := c.hashFile(files[i])
+                results[i] <- result{sum, err}
+            }
+        }()
+    }
+    go func() {
+        for i := range files {
+            next <- i
+        }
+        close(next)
+    }()
+    for i, f := range files {
+        done(f, <-results[i])
+    }
+}
+
+// Expand the arguments to the list of files to hash. Without -r a
+// directory is an error, with -r skeinsum hashes the regular files in
+// the directory tree in lexical order.
+//
+func (c *command) expand(args []string) []*file {
+    var files []*file
+    for _, arg := range args {
+        if !c.recursive || arg == "-" {
+            files = append(files, &file{name: arg, alg: c.alg})
+            continue
+        }
+        err := filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+            if err != nil {
+                files = append(files, &file{name: path, err: err})
+                return nil
+            }
+            if d.Type().IsRegular() || path == arg && !d.IsDir() {
+                files = append(files, &file{name: path, alg: c.alg})
+            }
+            return nil
+        })
+        if err != nil {
+            files = append(files, &file{name: arg, err: err})
+        }
+    }
+    return files
+}
+
+// Print the checksums of the files, return the exit status.
+//
+func (c *command) sum(args []string) int {
+    status := 0
+    c.hashAll(c.expand(args), func(f *file, r result) {
+        if r.err != nil {
+            c.error(r.err)
+            status = 1
+            return
+        }
+        shown, escaped := sumfile.Escape(f.name)
+        if escaped {
+            io.WriteString(c.stdout, "\\")
+        }
+        if c.tag {
+            fmt.Fprintf(c.stdout, "%s (%s) = %x\n", format.Name(c.alg), shown, r.sum)
+        } else {
+            fmt.Fprintf(c.stdout, "%x  %s\n", r.sum, shown)
+        }
+    })
+    return status
+}
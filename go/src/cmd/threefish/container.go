@@ -0,0 +1,320 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+package main
+
+import (
+    "bufio"
+    "context"
+    "crypto/cipher"
+    "crypto/rand"
+    "crypto/skein"
+    "crypto/skein/balloon"
+    "crypto/skein/etm"
+    "encoding/binary"
+    "errors"
+    "io"
+    "math"
+)
+
+const (
+    magic   = "3FSH"
+    version = 1
+
+    algorithmETM = 1 // Threefish counter mode with Skein-MAC, crypto/skein/etm
+
+    kdfKeyFile = 0 // Raw key file, the Skein KDF derives the file key
+    kdfBalloon = 1 // Passphrase, the balloon KDF derives the file key
+
+    saltSize        = 16
+    noncePrefixSize = 11
+    headerSize      = 4 + 1 + 1 + 1 + 1 + 4 + 4 + 1 + 4 + saltSize + noncePrefixSize
+
+    keySize        = 64
+    minKeyFileSize = etm.MinKeySize
+
+    minChunkSize = 1
+    maxChunkSize = 1 << 24
+
+    // Maximum memory of the balloon KDF a header may request, in KiB
+    maxMemory = 4 << 20
+
+    // Maximum number of balloon KDF rounds a header may request
+    maxTime = 64
+)
+
+var (
+    errFormat  = errors.New("not a threefish container or unsupported version")
+    errHeader  = errors.New("invalid container header")
+    errAuth    = errors.New("decryption failed: wrong key or corrupted data")
+    errTrunc   = errors.New("decryption failed: truncated data")
+    errTrail   = errors.New("decryption failed: data after the final chunk")
+    errTooLong = errors.New("input too long")
+    errKDF     = errors.New("the container needs a key file")
+    errPass    = errors.New("the container needs a passphrase")
+)
+
+// The container header. The header is the associated data of all
+// chunks, thus the body authenticates the header.
+//
+type header struct {
+    stateSize int
+    kdf       int
+    params    balloon.Params
+    chunkSize int
+    salt      [saltSize]byte
+    nonce     [noncePrefixSize]byte
+}
+
+// Create a header for encryption with a random salt and nonce prefix.
+//
+func newHeader(stateSize, kdf int, params balloon.Params, chunkSize int) (*header, error) {
+    h := &header{stateSize: stateSize, kdf: kdf, chunkSize: chunkSize}
+    if kdf == kdfBalloon {
+        h.params = params
+    }
+    if _, err := rand.Read(h.salt[:]); err != nil {
+        return nil, err
+    }
+    if _, err := rand.Read(h.nonce[:]); err != nil {
+        return nil, err
+    }
+    if err := h.check(); err != nil {
+        return nil, err
+    }
+    return h, nil
+}
+
+func (h *header) check() error {
+    if h.stateSize != skein.Skein256 && h.stateSize != skein.Skein512 && h.stateSize != skein.Skein1024 ||
+        h.chunkSize < minChunkSize || h.chunkSize > maxChunkSize {
+        return errHeader
+    }
+    switch h.kdf {
+    case kdfKeyFile:
+        if h.params != (balloon.Params{}) {
+            return errHeader
+        }
+    case kdfBalloon:
+        p := h.params
+        if !validParams(p) {
+            return errHeader
+        }
+    default:
+        return errHeader
+    }
+    return nil
+}
+
+// Check the balloon KDF parameters against the limits of a header. The
+// limits bound the work of a key derivation before decryption can verify
+// the header.
+//
+func validParams(p balloon.Params) bool {
+    return p.Time >= 1 && p.Time <= maxTime && p.Threads >= 1 && p.Threads <= balloon.MaxThreads &&
+        p.Memory >= p.Threads && p.Memory <= maxMemory
+}
+
+func (h *header) marshal() []byte {
+    b := make([]byte, 0, headerSize)
+    b = append(b, magic...)
+    b = append(b, version, algorithmETM, byte(h.stateSize/256), byte(h.kdf))
+    b = binary.LittleEndian.AppendUint32(b, uint32(h.params.Time))
+    b = binary.LittleEndian.AppendUint32(b, uint32(h.params.Memory))
+    b = append(b, byte(h.params.Threads))
+    b = binary.LittleEndian.AppendUint32(b, uint32(h.chunkSize))
+    b = append(b, h.salt[:]...)
+    return append(b, h.nonce[:]...)
+}
+
+func parseHeader(b []byte) (*header, error) {
+    if len(b) != headerSize || string(b[:4]) != magic || b[4] != version {
+        return nil, errFormat
+    }
+    if b[5] != algorithmETM {
+        return nil, errHeader
+    }
+    h := new(header)
+    h.stateSize = int(b[6]) * 256
+    h.kdf = int(b[7])
+    h.params.Time = int(binary.LittleEndian.Uint32(b[8:]))
+    h.params.Memory = int(binary.LittleEndian.Uint32(b[12:]))
+    h.params.Threads = int(b[16])
+    h.chunkSize = int(binary.LittleEndian.Uint32(b[17:]))
+    copy(h.salt[:], b[21:])
+    copy(h.nonce[:], b[21+saltSize:])
+    if err := h.check(); err != nil {
+        return nil, err
+    }
+    return h, nil
+}
+
+// Derive the file key from the secret and create the AEAD.
+//
+func (h *header) aead(ctx context.Context, secret []byte) (cipher.AEAD, error) {
+    var key []byte
+    var err error
+    if h.kdf == kdfBalloon {
+        key, err = balloon.Key(ctx, secret, h.salt[:], &h.params, keySize)
+    } else {
+        key, err = skein.DeriveKey(secret, h.salt[:], keySize)
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer clear(key)
+    return etm.New(key, h.stateSize)
+}
+
+// The nonce of chunk i: the nonce prefix, i as big endian uint32 and 1
+// for the final chunk, else 0.
+//
+func (h *header) chunkNonce(nonce []byte, i uint64, final bool) []byte {
+    copy(nonce, h.nonce[:])
+    binary.BigEndian.PutUint32(nonce[noncePrefixSize:], uint32(i))
+    nonce[etm.NonceSize-1] = 0
+    if final {
+        nonce[etm.NonceSize-1] = 1
+    }
+    return nonce
+}
+
+// Encrypt src to dst: the header followed by the sealed chunks. Each
+// chunk except the final chunk holds chunkSize bytes of plain data, the
+// final chunk holds the rest, possibly zero bytes.
+//
+func encrypt(ctx context.Context, dst io.Writer, src io.Reader, h *header, secret []byte) error {
+    aead, err := h.aead(ctx, secret)
+    if err != nil {
+        return err
+    }
+    ad := h.marshal()
+    if _, err := dst.Write(ad); err != nil {
+        return err
+    }
+    br := bufio.NewReaderSize(src, h.chunkSize+1)
+    plain := make([]byte, h.chunkSize)
+    sealed := make([]byte, 0, h.chunkSize+aead.Overhead())
+    nonce := make([]byte, etm.NonceSize)
+    for i := uint64(0); ; i++ {
+        if i > math.MaxUint32 {
+            return errTooLong
+        }
+        n, err := io.ReadFull(br, plain)
+        if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+            return err
+        }
+        final := err != nil
+        if !final {
+            if _, err := br.Peek(1); err == io.EOF {
+                final = true
+            } else if err != nil {
+                return err
+            }
+        }
+        sealed = aead.Seal(sealed[:0], h.chunkNonce(nonce, i, final), plain[:n], ad)
+        if _, err := dst.Write(sealed); err != nil {
+            return err
+        }
+        if final {
+            clear(plain)
+            return nil
+        }
+    }
+}
+
+// Read the header of an encrypted stream.
+//
+func readHeader(src io.Reader) (*header, []byte, error) {
+    b := make([]byte, headerSize)
+    if _, err := io.ReadFull(src, b); err != nil {
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            return nil, nil, errFormat
+        }
+        return nil, nil, err
+    }
+    h, err := parseHeader(b)
+    return h, b, err
+}
+
+// Decrypt src to dst. The secret must match the KDF of the header.
+// Decrypt writes the plain data of each chunk after it authenticated the
+// chunk. If decrypt fails dst may contain a part of the plain data.
+//
+func decrypt(ctx context.Context, dst io.Writer, src io.Reader, secret []byte, kdf int) error {
+    h, ad, err := readHeader(src)
+    if err != nil {
+        return err
+    }
+    if h.kdf != kdf {
+        if h.kdf == kdfKeyFile {
+            return errKDF
+        }
+        return errPass
+    }
+    aead, err := h.aead(ctx, secret)
+    if err != nil {
+        return err
+    }
+    br := bufio.NewReaderSize(src, h.chunkSize+aead.Overhead()+1)
+    sealed := make([]byte, h.chunkSize+aead.Overhead())
+    plain := make([]byte, 0, h.chunkSize)
+    nonce := make([]byte, etm.NonceSize)
+    for i := uint64(0); ; i++ {
+        if i > math.MaxUint32 {
+            return errTooLong
+        }
+        n, err := io.ReadFull(br, sealed)
+        if err == io.EOF {
+            return errTrunc
+        }
+        if err != nil && err != io.ErrUnexpectedEOF {
+            return err
+        }
+        final := err != nil
+        if !final {
+            if _, err := br.Peek(1); err == io.EOF {
+                final = true
+            } else if err != nil {
+                return err
+            }
+        }
+        plain, err = aead.Open(plain[:0], h.chunkNonce(nonce, i, final), sealed[:n], ad)
+        if err != nil {
+            // A chunk sealed as final that is followed by more data
+            if !final {
+                if _, e := aead.Open(plain[:0], h.chunkNonce(nonce, i, true), sealed[:n], ad); e == nil {
+                    return errTrail
+                }
+            } else if n == len(sealed) {
+                // A full chunk at the end that was not sealed as final
+                if _, e := aead.Open(plain[:0], h.chunkNonce(nonce, i, false), sealed[:n], ad); e == nil {
+                    return errTrunc
+                }
+            }
+            return errAuth
+        }
+        if _, err := dst.Write(plain); err != nil {
+            return err
+        }
+        if final {
+            clear(plain)
+            return nil
+        }
+    }
+}
@@ -0,0 +1,277 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// Threefish encrypts and decrypts files and streams.
+//
+// Usage:
+//
+//    threefish -e|-d -keyfile file | -passfile file | -passenv name [flags] [input]
+//
+// With no input, or when input is -, threefish reads standard input. It
+// writes to standard output unless the -o flag names an output file.
+// Exactly one of the secret flags selects the secret:
+//
+//    -keyfile file  The file that holds a raw key of at least 16 bytes.
+//    -passfile file The file that holds the passphrase in its first line.
+//    -passenv name  The environment variable that holds the passphrase.
+//
+// The other flags are:
+//
+//    -o file     Write the output to file, not the input file. Threefish
+//                replaces the file only on success.
+//    -a size     Encryption: the Threefish and Skein state size in bits,
+//                256, 512 or 1024. Default 512.
+//    -chunk n    Encryption: the chunk size in bytes. Default 65536.
+//    -time n     Encryption with passphrase: the balloon KDF rounds, at
+//                most 64.
+//    -memory n   Encryption with passphrase: the balloon KDF memory in KiB.
+//    -threads n  Encryption with passphrase: the balloon KDF lanes.
+//
+// The container starts with a header of 48 bytes, numbers are little
+// endian:
+//
+//    magic       4 bytes, "3FSH"
+//    version     1 byte, 1
+//    algorithm   1 byte, 1: Threefish counter mode with Skein-MAC, see
+//                package crypto/skein/etm
+//    state size  1 byte, Threefish state size / 256
+//    kdf         1 byte, 0: key file, 1: passphrase
+//    time        uint32, balloon KDF rounds, 1 to 64, 0 for a key file
+//    memory      uint32, balloon KDF memory in KiB, at most 4 GiB, 0 for a
+//                key file
+//    threads     1 byte, balloon KDF lanes, 0 for a key file
+//    chunk size  uint32
+//    salt        16 bytes
+//    nonce       11 bytes, nonce prefix
+//
+// The file key is the balloon KDF, see package crypto/skein/balloon, of
+// the passphrase and the salt, or the Skein KDF of the key file with the
+// salt as key identifier. The body is a sequence of chunks. Each chunk
+// except the final chunk holds chunk size bytes of plain data, the final
+// chunk holds the rest, possibly zero bytes. Each chunk is sealed with the
+// file key, the header as associated data and the nonce
+//
+//    nonce prefix || chunk number || final
+//
+// where the chunk number is a big endian uint32 and final is 1 for the
+// final chunk, else 0. Thus decryption detects modified, reordered,
+// truncated and appended chunks. Decryption writes the plain data of a
+// chunk only after it verified the chunk.
+//
+// The exit status is 0 on success, 1 if the encryption or decryption
+// failed and 2 for invalid flags.
+//
+package main
+
+import (
+    "bufio"
+    "context"
+    "crypto/skein"
+    "crypto/skein/balloon"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "strings"
+)
+
+const name = "threefish"
+
+func main() {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    status := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+    stop()
+    os.Exit(status)
+}
+
+// Run threefish with the arguments args and return the exit status.
+//
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+    fs := flag.NewFlagSet(name, flag.ContinueOnError)
+    fs.SetOutput(stderr)
+    fs.Usage = func() {
+        fmt.Fprintf(stderr, "usage: %s -e|-d -keyfile file | -passfile file | -passenv name [flags] [input]\n", name)
+        fs.PrintDefaults()
+    }
+    enc := fs.Bool("e", false, "encrypt")
+    dec := fs.Bool("d", false, "decrypt")
+    keyFile := fs.String("keyfile", "", "`file` with the raw key")
+    passFile := fs.String("passfile", "", "`file` with the passphrase in its first line")
+    passEnv := fs.String("passenv", "", "environment variable `name` of the passphrase")
+    output := fs.String("o", "", "output `file`, default standard output")
+    stateSize := fs.Int("a", skein.Skein512, "Threefish and Skein state `size` in bits")
+    chunkSize := fs.Int("chunk", 1<<16, "chunk size in bytes")
+    var params balloon.Params
+    fs.IntVar(&params.Time, "time", balloon.DefaultParams.Time, "balloon KDF rounds")
+    fs.IntVar(&params.Memory, "memory", balloon.DefaultParams.Memory, "balloon KDF memory in KiB")
+    fs.IntVar(&params.Threads, "threads", balloon.DefaultParams.Threads, "balloon KDF lanes")
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+
+    if *enc == *dec {
+        return usage(stderr, "exactly one of -e and -d is required")
+    }
+    if fs.NArg() > 1 {
+        return usage(stderr, "at most one input file is allowed")
+    }
+    secret, kdf, err := loadSecret(*keyFile, *passFile, *passEnv)
+    if err != nil {
+        return usage(stderr, err.Error())
+    }
+    defer clear(secret)
+
+    var h *header
+    if *enc {
+        if *stateSize != skein.Skein256 && *stateSize != skein.Skein512 && *stateSize != skein.Skein1024 {
+            return usage(stderr, fmt.Sprintf("invalid state size %d", *stateSize))
+        }
+        if *chunkSize < minChunkSize || *chunkSize > maxChunkSize {
+            return usage(stderr, fmt.Sprintf("invalid chunk size %d", *chunkSize))
+        }
+        if kdf == kdfBalloon && !validParams(params) {
+            return usage(stderr, "invalid balloon KDF parameters")
+        }
+        if h, err = newHeader(*stateSize, kdf, params, *chunkSize); err != nil {
+            return fail(stderr, err)
+        }
+    }
+
+    input := fs.Arg(0)
+    if input == "-" {
+        input = ""
+    }
+    if input != "" && *output != "" && sameFile(input, *output) {
+        return usage(stderr, "the output file must differ from the input file")
+    }
+    var in io.Reader = stdin
+    if input != "" {
+        fd, err := os.Open(input)
+        if err != nil {
+            return fail(stderr, err)
+        }
+        defer fd.Close()
+        in = fd
+    }
+    // Write to a temporary file and replace the output file only on
+    // success, a failure keeps an existing output file
+    var out io.Writer = stdout
+    var tmp *os.File
+    if *output != "" {
+        if tmp, err = os.CreateTemp(filepath.Dir(*output), "."+filepath.Base(*output)+".*"); err != nil {
+            return fail(stderr, err)
+        }
+        out = tmp
+    }
+    bw := bufio.NewWriter(out)
+    if *enc {
+        err = encrypt(ctx, bw, in, h, secret)
+    } else {
+        err = decrypt(ctx, bw, in, secret, kdf)
+    }
+    if err == nil {
+        err = bw.Flush()
+    }
+    if tmp != nil {
+        if e := tmp.Close(); err == nil {
+            err = e
+        }
+        if err == nil {
+            err = os.Rename(tmp.Name(), *output)
+        }
+        if err != nil {
+            os.Remove(tmp.Name())
+        }
+    }
+    if err != nil {
+        return fail(stderr, err)
+    }
+    return 0
+}
+
+// Load the secret from exactly one of the sources, return the secret and
+// the matching KDF.
+//
+func loadSecret(keyFile, passFile, passEnv string) ([]byte, int, error) {
+    n := 0
+    for _, s := range []string{keyFile, passFile, passEnv} {
+        if s != "" {
+            n++
+        }
+    }
+    if n != 1 {
+        return nil, 0, errors.New("exactly one of -keyfile, -passfile and -passenv is required")
+    }
+    switch {
+    case keyFile != "":
+        key, err := os.ReadFile(keyFile)
+        if err != nil {
+            return nil, 0, err
+        }
+        if len(key) < minKeyFileSize {
+            clear(key)
+            return nil, 0, fmt.Errorf("key file %s: key shorter than %d bytes", keyFile, minKeyFileSize)
+        }
+        return key, kdfKeyFile, nil
+    case passFile != "":
+        data, err := os.ReadFile(passFile)
+        if err != nil {
+            return nil, 0, err
+        }
+        line, _, _ := strings.Cut(string(data), "\n")
+        clear(data)
+        return passphrase(strings.TrimSuffix(line, "\r"))
+    default:
+        value, ok := os.LookupEnv(passEnv)
+        if !ok {
+            return nil, 0, errors.New("environment variable " + passEnv + " is not set")
+        }
+        return passphrase(value)
+    }
+}
+
+func passphrase(s string) ([]byte, int, error) {
+    if s == "" {
+        return nil, 0, errors.New("empty passphrase")
+    }
+    return []byte(s), kdfBalloon, nil
+}
+
+// Report if the paths name the same existing file.
+//
+func sameFile(a, b string) bool {
+    fa, err := os.Stat(a)
+    if err != nil {
+        return false
+    }
+    fb, err := os.Stat(b)
+    return err == nil && os.SameFile(fa, fb)
+}
+
+func usage(stderr io.Writer, msg string) int {
+    fmt.Fprintf(stderr, "%s: %s\n", name, msg)
+    return 2
+}
+
+func fail(stderr io.Writer, err error) int {
+    fmt.Fprintf(stderr, "%s: %v\n", name, err)
+    return 1
+}
@@ -0,0 +1,320 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/skein/balloon"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Frozen container: Threefish-512, key file with key bytes 0, 1, ..., 31,
+// chunk size 16, salt bytes 0, 1, ..., 15 and nonce prefix bytes 0xa0,
+// 0xa1, ...
+const frozenContainer = "334653480101020000000000000000000010000000000102030405060708090a0b0c0d0e0fa0a1a2a3a4a5a6a7a8a9aa" +
+	"44a2d2161de633abd8f8880b97f7489e12e88fa697d0d686c8b7461b726afbaaf33d1d2e15d3224a9cb7215e660601c8" +
+	"13eadb3aed586b3ad3bd7d450a6a534518a1ba7f8d7c6be9da9195b59a1fa92a17f1a8da4a90220c010b6d9999cb7029" +
+	"95ce7248a2740d2affdd56afc3a258f60c62fd2eff29f3d52e86e147bd3e4c4f6abfa0f5a24038c9ae1d37"
+
+const frozenPlain = "The quick brown fox jumps over the lazy dog"
+
+// Cheap balloon KDF parameters for the tests
+var testParams = balloon.Params{Time: 1, Memory: 8, Threads: 1}
+
+func testKey() []byte {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func seal(t *testing.T, h *header, secret, plain []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := encrypt(context.Background(), &b, bytes.NewReader(plain), h, secret); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func open(secret, sealed []byte, kdf int) ([]byte, error) {
+	var b bytes.Buffer
+	err := decrypt(context.Background(), &b, bytes.NewReader(sealed), secret, kdf)
+	return b.Bytes(), err
+}
+
+func TestFrozenContainer(t *testing.T) {
+	h := &header{stateSize: 512, kdf: kdfKeyFile, chunkSize: 16}
+	for i := range h.salt {
+		h.salt[i] = byte(i)
+	}
+	for i := range h.nonce {
+		h.nonce[i] = byte(0xa0 + i)
+	}
+	sealed := seal(t, h, testKey(), []byte(frozenPlain))
+	if got := hex.EncodeToString(sealed); got != frozenContainer {
+		t.Errorf("got %s, want %s", got, frozenContainer)
+	}
+	want, _ := hex.DecodeString(frozenContainer)
+	plain, err := open(testKey(), want, kdfKeyFile)
+	if err != nil || string(plain) != frozenPlain {
+		t.Errorf("got %q, %v", plain, err)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	h, err := newHeader(1024, kdfBalloon, balloon.Params{Time: 3, Memory: 1 << 20, Threads: 255}, 1<<24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := h.marshal()
+	if len(b) != headerSize || headerSize != 48 {
+		t.Fatalf("header size %d, %d", len(b), headerSize)
+	}
+	p, err := parseHeader(b)
+	if err != nil || *p != *h {
+		t.Errorf("got %+v, %v, want %+v", p, err, h)
+	}
+	// A key file header carries no balloon parameters
+	h, _ = newHeader(256, kdfKeyFile, balloon.DefaultParams, 100)
+	if h.params != (balloon.Params{}) {
+		t.Errorf("key file header with parameters %+v", h.params)
+	}
+
+	modify := func(i int, v byte) []byte {
+		m := append([]byte(nil), b...)
+		m[i] = v
+		return m
+	}
+	for _, m := range [][]byte{
+		b[:headerSize-1],
+		modify(0, 'x'), // magic
+		modify(4, 2),   // version
+		modify(5, 2),   // algorithm
+		modify(6, 3),   // state size
+		modify(7, 2),   // kdf
+		modify(8, 0),   // time
+		modify(16, 0),  // threads
+		modify(15, 1),  // memory above the limit
+		modify(20, 2),  // chunk size above the limit
+		modify(8, 65),  // time above the limit
+		modify(11, 1),  // time above the limit
+	} {
+		if _, err := parseHeader(m); err == nil {
+			t.Errorf("accepted header %x", m)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	chunk := 100
+	for _, stateSize := range []int{256, 512, 1024} {
+		for _, kdf := range []int{kdfKeyFile, kdfBalloon} {
+			h, err := newHeader(stateSize, kdf, testParams, chunk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, n := range []int{0, 1, chunk - 1, chunk, chunk + 1, 3 * chunk, 3*chunk + 17} {
+				plain := testData(n)
+				sealed := seal(t, h, testKey(), plain)
+				chunks := (n + chunk - 1) / chunk
+				if chunks == 0 {
+					chunks = 1
+				}
+				if len(sealed) != headerSize+n+chunks*32 {
+					t.Errorf("%d, %d, %d: sealed length %d", stateSize, kdf, n, len(sealed))
+				}
+				got, err := open(testKey(), sealed, kdf)
+				if err != nil || !bytes.Equal(got, plain) {
+					t.Errorf("%d, %d, %d: got %v", stateSize, kdf, n, err)
+				}
+			}
+		}
+	}
+}
+
+func TestTamper(t *testing.T) {
+	chunk := 64
+	h, _ := newHeader(512, kdfKeyFile, testParams, chunk)
+	key := testKey()
+	sealed := seal(t, h, key, testData(3*chunk+10))
+	size := chunk + 32 // sealed chunk size
+	body := sealed[headerSize:]
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	flip := func(i int) []byte {
+		m := append([]byte(nil), sealed...)
+		m[i] ^= 1
+		return m
+	}
+	tests := []struct {
+		name   string
+		sealed []byte
+		err    error
+	}{
+		{"salt", flip(25), errAuth},
+		{"nonce", flip(headerSize - 1), errAuth},
+		{"chunk size", flip(17), errAuth},
+		{"body", flip(headerSize + size + 5), errAuth},
+		{"tag", flip(len(sealed) - 1), errAuth},
+		{"swapped", join(sealed[:headerSize], body[size:2*size], body[:size], body[2*size:]), errAuth},
+		{"header only", sealed[:headerSize], errTrunc},
+		{"final dropped", sealed[:headerSize+3*size], errTrunc},
+		{"cut chunk", sealed[:len(sealed)-5], errAuth},
+		{"appended", join(sealed, body[:size]), errAuth},
+		{"short header", sealed[:headerSize-1], errFormat},
+	}
+	for _, test := range tests {
+		if _, err := open(key, test.sealed, kdfKeyFile); err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+	}
+	if _, err := open(key[1:], sealed, kdfKeyFile); err != errAuth {
+		t.Errorf("wrong key: got error %v", err)
+	}
+	if _, err := open(key, sealed, kdfBalloon); err != errKDF {
+		t.Errorf("passphrase for key file: got error %v", err)
+	}
+
+	// A chunk appended after a full final chunk
+	full := seal(t, h, key, testData(2*chunk))
+	if _, err := open(key, join(full, full[headerSize:headerSize+size]), kdfKeyFile); err != errTrail {
+		t.Errorf("appended to full chunk: got error %v", err)
+	}
+
+	h, _ = newHeader(512, kdfBalloon, testParams, chunk)
+	if _, err := open(key, seal(t, h, key, nil), kdfKeyFile); err != errPass {
+		t.Errorf("key file for passphrase: got error %v", err)
+	}
+}
+
+// Run threefish, return the exit status, standard output and standard error.
+func runTool(t *testing.T, ctx context.Context, stdin []byte, args ...string) (int, []byte, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	status := run(ctx, args, bytes.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.Bytes(), stderr.String()
+}
+
+func TestCommand(t *testing.T) {
+	t.Chdir(t.TempDir())
+	ctx := context.Background()
+	plain := testData(70000)
+	if err := os.WriteFile("plain", plain, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("key", testKey(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("pass", []byte("correct horse\r\nsecond line\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("THREEFISH_TEST_PASS", "correct horse")
+	cheap := []string{"-time", "1", "-memory", "64", "-threads", "2"}
+
+	// Key file, file to file
+	status, _, errOut := runTool(t, ctx, nil, "-e", "-keyfile", "key", "-a", "1024", "-o", "sealed", "plain")
+	if status != 0 {
+		t.Fatalf("encrypt: got %d, %q", status, errOut)
+	}
+	status, out, errOut := runTool(t, ctx, nil, "-d", "-keyfile", "key", "sealed")
+	if status != 0 || !bytes.Equal(out, plain) {
+		t.Errorf("decrypt: got %d, %q", status, errOut)
+	}
+
+	// Passphrase from a file and from the environment, stdin to stdout
+	status, sealed, errOut := runTool(t, ctx, plain, append([]string{"-e", "-passfile", "pass", "-chunk", "1000"}, cheap...)...)
+	if status != 0 {
+		t.Fatalf("encrypt: got %d, %q", status, errOut)
+	}
+	status, out, errOut = runTool(t, ctx, sealed, "-d", "-passenv", "THREEFISH_TEST_PASS", "-")
+	if status != 0 || !bytes.Equal(out, plain) {
+		t.Errorf("decrypt: got %d, %q", status, errOut)
+	}
+
+	// A failed decryption creates no output file and keeps an existing one
+	t.Setenv("THREEFISH_TEST_PASS", "wrong horse")
+	status, _, errOut = runTool(t, ctx, sealed, "-d", "-passenv", "THREEFISH_TEST_PASS", "-o", "out")
+	if status != 1 || !strings.Contains(errOut, errAuth.Error()) {
+		t.Errorf("wrong passphrase: got %d, %q", status, errOut)
+	}
+	if _, err := os.Stat("out"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("output file created: %v", err)
+	}
+	status, _, _ = runTool(t, ctx, sealed[:len(sealed)-1], "-d", "-keyfile", "key", "-o", "plain")
+	if data, err := os.ReadFile("plain"); status != 1 || err != nil || !bytes.Equal(data, plain) {
+		t.Errorf("existing output file modified: got %d, %v", status, err)
+	}
+	if entries, _ := os.ReadDir("."); len(entries) != 4 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+
+	// The output must not overwrite the input
+	if status, _, _ = runTool(t, ctx, nil, "-e", "-keyfile", "key", "-o", "plain", "plain"); status != 2 {
+		t.Errorf("output is input: got status %d", status)
+	}
+	if data, _ := os.ReadFile("plain"); !bytes.Equal(data, plain) {
+		t.Error("input file modified")
+	}
+
+	// Cancellation stops the key derivation
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	status, _, errOut = runTool(t, canceled, plain, "-e", "-passfile", "pass", "-memory", "1024", "-threads", "1")
+	if status != 1 || !strings.Contains(errOut, context.Canceled.Error()) {
+		t.Errorf("canceled: got %d, %q", status, errOut)
+	}
+
+	if err := os.WriteFile("short", []byte("short key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-keyfile", "key"},
+		{"-e", "-d", "-keyfile", "key"},
+		{"-e"},
+		{"-e", "-keyfile", "key", "-passfile", "pass"},
+		{"-e", "-keyfile", "short"},
+		{"-e", "-keyfile", "missing"},
+		{"-e", "-passenv", "THREEFISH_TEST_UNSET"},
+		{"-e", "-keyfile", "key", "-a", "384"},
+		{"-e", "-keyfile", "key", "-chunk", "0"},
+		{"-e", "-passfile", "pass", "-threads", "0"},
+		{"-e", "-passfile", "pass", "-time", "65"},
+		{"-e", "-keyfile", "key", "a", "b"},
+	} {
+		if status, _, _ := runTool(t, ctx, nil, args...); status != 2 {
+			t.Errorf("%v: got status %d, want 2", args, status)
+		}
+	}
+}
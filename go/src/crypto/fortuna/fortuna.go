@@ -0,0 +1,438 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements the Fortuna random number generator.
+//
+// The implementation is a port of the Java FortunaGenerator of this
+// software suite. Instead of SHA-256 and AES it uses Skein-512 (with
+// 256 bit output) to hash the entropy pools and Threefish-256 in counter
+// mode as generator.
+//
+// Fortuna is a continuously-seeded pseudo-random number generator (PRNG)
+// and is composed of two major pieces: the entropy accumulator and the
+// generator function. The former takes in random bits and incorporates them
+// into the generator's state. The latter takes this base entropy and generates
+// pseudo-random bits from it.
+//
+// There are some things users of this package must be aware of:
+//
+// Adding Random Data: this package does not do any polling of random
+// sources, but rather provides an interface for adding entropy data
+// (additional seed). Applications that use this code must provide this
+// mechanism.
+//
+// Storing the Seed: Fortuna returns a 64 byte seed status that an
+// application may store and use to seed Fortuna after a restart. The seed
+// file functions implement the seed file management as described in
+// Practical Cryptography.
+//
+// References: Niels Ferguson and Bruce Schneier, Practical Cryptography,
+// pp. 155--184. Wiley Publishing, Indianapolis, 2003. ISBN 0-471-22357-3.
+//
+package fortuna
+
+import (
+    "crypto/skein"
+    "crypto/threefish"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    SeedFileSize = 64 // Size of the seed status and the seed file
+    NumPools     = 32 // Number of entropy pools
+    MinPoolSize  = 64 // Bytes in pool 0 that trigger a reseed
+
+    // Maximum size of the data for AddSeedMaterialPool, the length is
+    // hashed as one byte
+    MaxEventSize = 255
+
+    // Minimum time between two reseeds
+    ReseedInterval = 100 * time.Millisecond
+)
+
+// Returns the current time, tests replace it to control reseeding.
+var now = time.Now
+
+// A Fortuna is an instance of the Fortuna random number generator.
+//
+// Fortuna implements io.Reader. All functions are safe for concurrent
+// use by several goroutines.
+//
+type Fortuna struct {
+    mu          sync.Mutex
+    generator   *generator
+    pools       [NumPools]*skein.Skein
+    lastReseed  time.Time
+    pool        int
+    pool0Count  int
+    reseedCount uint64
+    initialized bool
+
+    // A temporary buffer to serve random bytes
+    buffer []byte
+    // The index into buffer of where the next byte will come from
+    ndx int
+}
+
+type notSeededError int
+
+func (n notSeededError) Error() string {
+    return "crypto/fortuna: Fortuna generator not initialized/seeded"
+}
+
+type PoolError int
+
+func (p PoolError) Error() string {
+    return "crypto/fortuna: pool number out of range " + strconv.Itoa(int(p))
+}
+
+type EventSizeError int
+
+func (e EventSizeError) Error() string {
+    return "crypto/fortuna: seed material too large " + strconv.Itoa(int(e))
+}
+
+type SeedSizeError int
+
+func (s SeedSizeError) Error() string {
+    return "crypto/fortuna: invalid seed file size " + strconv.Itoa(int(s))
+}
+
+// New creates and returns a Fortuna generator.
+//
+// If seed is nil the application must add seed material and call
+// SetSeedStatus or use a seed file before it reads random data.
+//
+// seed
+//      The initial seed of the generator
+//
+func New(seed []byte) *Fortuna {
+    f := new(Fortuna)
+    f.generator = newGenerator()
+    for i := range f.pools {
+        f.pools[i] = newPoolHash()
+    }
+    f.buffer = make([]byte, 256)
+    if seed != nil {
+        f.generator.init(seed)
+        f.fillBlock()
+        f.initialized = true
+    }
+    return f
+}
+
+func newPoolHash() *skein.Skein {
+    h, _ := skein.New(skein.Skein512, 256) // Ignore error - we use correct sizes here
+    return h
+}
+
+// Reseed the generator if pool 0 collected enough entropy, then refill
+// the buffer.
+//
+// Reseed number r uses pool i only if 2^i divides r, thus higher pools
+// collect entropy over longer periods.
+//
+func (f *Fortuna) fillBlock() {
+    if f.pool0Count >= MinPoolSize && now().Sub(f.lastReseed) > ReseedInterval {
+        f.reseedCount++
+        for i := 0; i < NumPools; i++ {
+            if i > 0 && f.reseedCount%(uint64(1)<<uint(i)) != 0 {
+                break
+            }
+            f.generator.addRandomBytes(f.pools[i].DoFinal())
+        }
+        f.lastReseed = now()
+        f.pool0Count = 0
+    }
+    f.generator.nextBytes(f.buffer)
+}
+
+// Read fills p with random data.
+//
+// Read returns an error if the generator was not seeded.
+//
+func (f *Fortuna) Read(p []byte) (int, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if !f.initialized {
+        return 0, notSeededError(0)
+    }
+    if f.ndx >= len(f.buffer) {
+        f.fillBlock()
+        f.ndx = 0
+    }
+    count := 0
+    for count < len(p) {
+        amount := copy(p[count:], f.buffer[f.ndx:])
+        count += amount
+        f.ndx += amount
+        if f.ndx >= len(f.buffer) {
+            f.fillBlock()
+            f.ndx = 0
+        }
+    }
+    return count, nil
+}
+
+// Adds new random data (entropy) to an entropy pool.
+//
+// This functions adds entropy data to the current pool. Fortuna uses
+// 32 pools to gather entropy. After the function added the entropy to
+// the pool it increments the current pool number modulo 32.
+//
+// Only if pool 0 (zero) got enough entropy (min. 64 bytes) then Fortuna
+// uses the pools to perform a real re-seed. If an application uses this
+// function to add entropy it shall take this behaviour into consideration.
+//
+// data
+//      Buffer with new entropy data. If the current pool is 0 then the
+//      function adds the length of the buffer to the overall entropy
+//      count that controls re-seed.
+//
+func (f *Fortuna) AddSeedMaterial(data []byte) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    f.pools[f.pool].Update(data)
+    if f.pool == 0 {
+        f.pool0Count += len(data)
+    }
+    f.pool = (f.pool + 1) % NumPools
+}
+
+// Adds new random data (entropy) to the specified entropy pool.
+//
+// The function hashes the length of the data (one byte) and the data,
+// thus an entropy source may use this function to feed its data
+// into the pools in a round robin fashion. The one byte length limits
+// the data to MaxEventSize bytes, a source splits larger data.
+//
+// poolNumber
+//      Specifies which pool receives the entropy data
+// data
+//      Buffer with new entropy data, at most MaxEventSize bytes. If the
+//      specified pool is 0 then the function adds the length of the data
+//      to the overall entropy count that controls re-seed.
+//
+func (f *Fortuna) AddSeedMaterialPool(poolNumber int, data []byte) error {
+    if poolNumber < 0 || poolNumber >= NumPools {
+        return PoolError(poolNumber)
+    }
+    if len(data) > MaxEventSize {
+        return EventSizeError(len(data))
+    }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    f.pools[poolNumber].Update([]byte{byte(len(data))})
+    f.pools[poolNumber].Update(data)
+    if poolNumber == 0 {
+        f.pool0Count += len(data)
+    }
+    return nil
+}
+
+// Return the generator's seed status.
+//
+// An application may get the seed status, store it in a safe place
+// and retrieve it to seed a new Fortuna PRNG instance.
+//
+func (f *Fortuna) SeedStatus() []byte {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    seed := make([]byte, SeedFileSize)
+    f.generator.nextBytes(seed)
+    return seed
+}
+
+// Seed the generator with a previously saved seed.
+//
+// seedStatus
+//      The generator's seed
+//
+func (f *Fortuna) SetSeedStatus(seedStatus []byte) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    f.generator.init(seedStatus)
+    f.fillBlock()
+    f.ndx = 0
+    f.initialized = true
+}
+
+// Write a new seed file.
+//
+// Applications should write the seed file regularly, for example every
+// ten minutes, and before they exit.
+//
+// name
+//      Path name of the seed file
+//
+func (f *Fortuna) WriteSeedFile(name string) error {
+    return os.WriteFile(name, f.SeedStatus(), 0600)
+}
+
+// Seed the generator from a seed file and write a new seed file
+// immediately.
+//
+// Writing the new seed file before the generator produces any data
+// ensures that the same seed is never used twice, even if the
+// application crashes.
+//
+// name
+//      Path name of the seed file
+//
+func (f *Fortuna) UpdateSeedFile(name string) error {
+    seed, err := os.ReadFile(name)
+    if err != nil {
+        return err
+    }
+    if len(seed) != SeedFileSize {
+        return SeedSizeError(len(seed))
+    }
+    f.SetSeedStatus(seed)
+    if err := f.WriteSeedFile(name); err != nil {
+        os.Remove(name)
+        return err
+    }
+    return nil
+}
+
+// The Fortuna generator function. The generator is a PRNG in its own right;
+// Fortuna itself is basically a wrapper around this generator that manages
+// re-seeding in a secure way.
+//
+// The generator runs Threefish-256 in counter mode.
+//
+type generator struct {
+    cipher  *threefish.Cipher
+    hash    *skein.Skein
+    counter []byte
+    key     []byte
+
+    // A temporary buffer to serve random bytes
+    buffer []byte
+    // The index into buffer of where the next byte will come from
+    ndx int
+}
+
+// Maximum number of bytes the generator produces before it changes the key
+const generatorLimit = 1 << 20
+
+func newGenerator() *generator {
+    g := new(generator)
+    g.hash = newPoolHash()
+    g.key = make([]byte, 32)
+    g.cipher, _ = threefish.New(g.key, nil)
+    g.counter = make([]byte, g.cipher.BlockSize())
+    g.buffer = make([]byte, g.cipher.BlockSize())
+    return g
+}
+
+func (g *generator) nextBytes(out []byte) {
+    for count := 0; ; {
+        amount := len(out) - count
+        if amount > generatorLimit {
+            amount = generatorLimit
+        }
+        g.nextBytesInternal(out[count : count+amount])
+        count += amount
+
+        // Generate a new key after each request
+        for i := 0; i < len(g.key); i += len(g.counter) {
+            g.fillBlock()
+            copy(g.key[i:], g.buffer)
+        }
+        g.resetKey()
+        // Discard the buffer, it holds the new key
+        g.fillBlock()
+        g.ndx = 0
+        if count >= len(out) {
+            break
+        }
+    }
+}
+
+func (g *generator) addRandomBytes(seed []byte) {
+    g.hash.Update(g.key)
+    g.hash.Update(seed)
+    copy(g.key, g.hash.DoFinal())
+    g.resetKey()
+    g.incrementCounter()
+}
+
+func (g *generator) fillBlock() {
+    g.cipher.Encrypt(g.buffer, g.counter)
+    g.incrementCounter()
+}
+
+func (g *generator) init(seed []byte) {
+    for i := range g.key {
+        g.key[i] = 0
+    }
+    for i := range g.counter {
+        g.counter[i] = 0
+    }
+    if seed != nil {
+        g.addRandomBytes(seed)
+    }
+    g.fillBlock()
+}
+
+func (g *generator) nextBytesInternal(out []byte) {
+    if len(out) == 0 {
+        return
+    }
+    if g.ndx >= len(g.buffer) {
+        g.fillBlock()
+        g.ndx = 0
+    }
+    count := 0
+    for count < len(out) {
+        amount := copy(out[count:], g.buffer[g.ndx:])
+        count += amount
+        g.ndx += amount
+        if g.ndx >= len(g.buffer) {
+            g.fillBlock()
+            g.ndx = 0
+        }
+    }
+}
+
+// Resets the cipher's key. This is done after every reseed, which combines
+// the old key and the seed, and processes that through the hash function.
+//
+func (g *generator) resetKey() {
+    g.cipher, _ = threefish.New(g.key, nil)
+}
+
+// Increment the counter as a little-endian unsigned integer by one.
+//
+func (g *generator) incrementCounter() {
+    for i := range g.counter {
+        g.counter[i]++
+        if g.counter[i] != 0 {
+            break
+        }
+    }
+}
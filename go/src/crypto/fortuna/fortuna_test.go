@@ -0,0 +1,211 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package fortuna
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testSeed = []byte("Fortuna test seed, not random at all")
+
+func TestDeterministic(t *testing.T) {
+	f1 := New(testSeed)
+	f2 := New(testSeed)
+	a := make([]byte, 1000)
+	b := make([]byte, 1000)
+	f1.Read(a)
+	for i := 0; i < len(b); i += 33 {
+		end := i + 33
+		if end > len(b) {
+			end = len(b)
+		}
+		f2.Read(b[i:end])
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("generators with same seed differ")
+	}
+	f1.Read(b)
+	if bytes.Equal(a, b) {
+		t.Error("generator repeats output")
+	}
+}
+
+// A request longer than generatorLimit changes the key between chunks,
+// the output after the change must not reveal the new key.
+func TestGeneratorLimit(t *testing.T) {
+	g := newGenerator()
+	g.init(testSeed)
+	out := make([]byte, generatorLimit+256)
+	g.nextBytes(out)
+
+	// Compute the key after the first chunk
+	ref := newGenerator()
+	ref.init(testSeed)
+	ref.nextBytesInternal(make([]byte, generatorLimit))
+	key := make([]byte, len(ref.key))
+	for i := 0; i < len(key); i += len(ref.counter) {
+		ref.fillBlock()
+		copy(key[i:], ref.buffer)
+	}
+	if bytes.Contains(out, key) {
+		t.Errorf("output contains the generator key %x", key)
+	}
+	if bytes.Equal(out[generatorLimit:generatorLimit+len(key)], key) {
+		t.Error("output at the chunk boundary equals the generator key")
+	}
+}
+
+func TestNotSeeded(t *testing.T) {
+	f := New(nil)
+	if _, err := f.Read(make([]byte, 10)); err == nil {
+		t.Error("expected an error for an unseeded generator")
+	}
+	f.SetSeedStatus(testSeed)
+	if _, err := f.Read(make([]byte, 10)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := f.AddSeedMaterialPool(NumPools, testSeed); err == nil {
+		t.Error("expected an error for an invalid pool number")
+	}
+}
+
+func TestEventSize(t *testing.T) {
+	f := New(testSeed)
+	if err := f.AddSeedMaterialPool(0, make([]byte, MaxEventSize)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	// The one byte length of 256 bytes would be 0, thus framing of the
+	// pool data would be ambiguous
+	if err := f.AddSeedMaterialPool(0, make([]byte, MaxEventSize+1)); err == nil {
+		t.Error("expected an error for too much seed material")
+	}
+	if f.pool0Count != MaxEventSize {
+		t.Errorf("pool 0 count %d after rejected seed material", f.pool0Count)
+	}
+}
+
+func TestReseed(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	f1 := New(testSeed)
+	f2 := New(testSeed)
+	a := make([]byte, 300)
+	b := make([]byte, 300)
+
+	// Not enough entropy in pool 0, no reseed
+	f1.AddSeedMaterial(make([]byte, MinPoolSize-1))
+	f1.Read(a)
+	f2.Read(b)
+	if !bytes.Equal(a, b) {
+		t.Error("generator reseeded without enough entropy")
+	}
+
+	// Pool 0 is full, but reseed interval not yet over
+	f1.AddSeedMaterialPool(0, make([]byte, 1))
+	f2.AddSeedMaterialPool(0, make([]byte, 1))
+	f1.AddSeedMaterialPool(0, make([]byte, MinPoolSize))
+	f1.lastReseed = clock
+	f1.Read(a)
+	f2.Read(b)
+	if !bytes.Equal(a, b) {
+		t.Error("generator reseeded too early")
+	}
+
+	clock = clock.Add(2 * ReseedInterval)
+	f1.Read(a)
+	f2.Read(b)
+	if bytes.Equal(a, b) {
+		t.Error("generator did not reseed")
+	}
+	if f1.reseedCount != 1 || f1.pool0Count != 0 {
+		t.Errorf("reseed count %d, pool 0 count %d", f1.reseedCount, f1.pool0Count)
+	}
+}
+
+func TestSeedStatus(t *testing.T) {
+	f1 := New(testSeed)
+	status := f1.SeedStatus()
+	if len(status) != SeedFileSize {
+		t.Fatalf("seed status size %d", len(status))
+	}
+	f2 := New(nil)
+	f2.SetSeedStatus(status)
+	f3 := New(status)
+	a := make([]byte, 100)
+	b := make([]byte, 100)
+	f2.Read(a)
+	f3.Read(b)
+	if !bytes.Equal(a, b) {
+		t.Error("generators with same seed status differ")
+	}
+}
+
+func TestSeedFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "seed")
+	f1 := New(testSeed)
+	if err := f1.WriteSeedFile(name); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	saved, _ := os.ReadFile(name)
+
+	f2 := New(nil)
+	if err := f2.UpdateSeedFile(name); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	updated, _ := os.ReadFile(name)
+	if len(updated) != SeedFileSize || bytes.Equal(saved, updated) {
+		t.Error("seed file was not updated")
+	}
+	f3 := New(saved)
+	a := make([]byte, 100)
+	b := make([]byte, 100)
+	f2.Read(a)
+	f3.Read(b)
+	if !bytes.Equal(a, b) {
+		t.Error("generator not seeded from seed file")
+	}
+
+	os.WriteFile(name, []byte("short"), 0600)
+	if err := f2.UpdateSeedFile(name); err == nil {
+		t.Error("expected an error for a short seed file")
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	f := New(testSeed)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			buf := make([]byte, 100)
+			for j := 0; j < 100; j++ {
+				f.Read(buf)
+				f.AddSeedMaterial(buf[:i+1])
+			}
+		}(i)
+	}
+	wg.Wait()
+}
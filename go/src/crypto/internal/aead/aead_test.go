@@ -0,0 +1,42 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package aead
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSliceForAppend(t *testing.T) {
+	// Enough capacity: head aliases in
+	in := make([]byte, 3, 10)
+	copy(in, "abc")
+	head, tail := SliceForAppend(in, 5)
+	if len(head) != 8 || len(tail) != 5 || &head[0] != &in[0] || &tail[0] != &head[3] {
+		t.Error("SliceForAppend did not reuse the capacity")
+	}
+	// Not enough capacity: head is a copy
+	head, tail = SliceForAppend(in, 8)
+	if len(head) != 11 || len(tail) != 8 || &head[0] == &in[0] || !bytes.Equal(head[:3], []byte("abc")) {
+		t.Error("SliceForAppend did not copy the contents")
+	}
+	head, tail = SliceForAppend(nil, 0)
+	if len(head) != 0 || len(tail) != 0 {
+		t.Error("SliceForAppend of nil")
+	}
+}
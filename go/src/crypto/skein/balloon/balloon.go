@@ -0,0 +1,276 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements a memory-hard key derivation in the style of
+// Balloon hashing with Skein-512 and Threefish-1024.
+//
+// An attacker who guesses passwords must spend the memory of a derivation
+// for each guess, thus the derivation resists attacks with GPUs and custom
+// hardware better than an iterated hash, see package crypto/skein/password.
+// The parameters tune the cost:
+//
+//  - Memory: the memory in KiB, split into Threads lanes of 128 byte
+//    blocks,
+//  - Time: the number of mixing rounds over each lane,
+//  - Threads: the number of lanes, each lane runs in its own goroutine.
+//
+// All hashes are Skein-512 with the personalization "crypto/skein/balloon",
+// the first input byte separates the uses. The parameter string P is
+//
+//    Time || Memory || Threads || keyLen || len(salt) || salt
+//
+// with little endian uint32 numbers. Lane l of n blocks computes
+//
+//    K || B[0] = H(1 || P || l || len(password) || password), 256 bytes
+//    B[i]      = E(K, {i, 0}, B[i-1]) xor B[i-1], i = 1, ..., n-1
+//
+// where E is Threefish-1024 with key K and the tweak {i, 0}. Each round r
+// then mixes the lane block by block:
+//
+//    B[i] = H(3 || r || i || B[i-1] || B[i] || B[o0] || B[o1] || B[o2])
+//
+// with B[-1] = B[n-1] and the little endian uint64 numbers r and i. The
+// other blocks oj are the little endian uint64 j of the Skein-512-MAC of
+// r || i, modulo n, the MAC key is H(2 || P || l). The memory access
+// pattern thus depends on the salt but not on the password. The derived
+// key is
+//
+//    H(4 || P || B0[n-1] || B1[n-1] || ...)
+//
+// of keyLen bytes over the last block of each lane.
+//
+package balloon
+
+import (
+    "context"
+    "crypto/skein"
+    "crypto/subtle"
+    "crypto/threefish"
+    "encoding/binary"
+    "strconv"
+    "sync"
+)
+
+const (
+    BlockSize = 128 // Size of a memory block in bytes
+
+    // Maximum number of lanes
+    MaxThreads = 255
+
+    others = 3 // Number of other blocks mixed into each block
+
+    // Check for cancellation after this number of blocks
+    checkInterval = 1024
+)
+
+// Hash domains, the first input byte of each hash
+const (
+    domainSeed  = 1
+    domainIndex = 2
+    domainMix   = 3
+    domainKey   = 4
+)
+
+var personalization = []byte("crypto/skein/balloon")
+
+// Params holds the cost parameters of a key derivation.
+//
+type Params struct {
+    Time    int // Number of mixing rounds, at least 1
+    Memory  int // Memory in KiB, at least Threads
+    Threads int // Number of lanes computed in parallel, 1 to MaxThreads
+}
+
+// DefaultParams are the recommended parameters for interactive logins:
+// 16 MiB of memory, two rounds and four lanes.
+//
+var DefaultParams = Params{Time: 2, Memory: 16 * 1024, Threads: 4}
+
+type timeError int
+
+func (t timeError) Error() string {
+    return "crypto/skein/balloon: invalid time " + strconv.Itoa(int(t))
+}
+
+type memoryError int
+
+func (m memoryError) Error() string {
+    return "crypto/skein/balloon: invalid memory " + strconv.Itoa(int(m))
+}
+
+type threadsError int
+
+func (t threadsError) Error() string {
+    return "crypto/skein/balloon: invalid number of threads " + strconv.Itoa(int(t))
+}
+
+type keyLengthError int
+
+func (k keyLengthError) Error() string {
+    return "crypto/skein/balloon: invalid key length " + strconv.Itoa(int(k))
+}
+
+// Check the parameters.
+//
+func (p *Params) check() error {
+    if p.Time < 1 || uint64(p.Time) > 1<<32-1 {
+        return timeError(p.Time)
+    }
+    if p.Threads < 1 || p.Threads > MaxThreads {
+        return threadsError(p.Threads)
+    }
+    if p.Memory < p.Threads || uint64(p.Memory) > 1<<32-1 {
+        return memoryError(p.Memory)
+    }
+    return nil
+}
+
+func newHash(outputSize int) *skein.Skein {
+    h, _ := skein.NewWithParams(&skein.Params{StateSize: skein.Skein512, OutputSize: outputSize,
+        Personalization: personalization}) // Ignore error - we use correct sizes here
+    return h
+}
+
+func putUint32(b []byte, v int) []byte {
+    return binary.LittleEndian.AppendUint32(b, uint32(v))
+}
+
+// Key derives a key from a password.
+//
+// Key returns the error of ctx if ctx is done before the derivation
+// completes.
+//
+// ctx
+//      The context to cancel the derivation
+// password
+//      The password
+// salt
+//      The salt, applications should use at least 16 random bytes
+// p
+//      The cost parameters
+// keyLen
+//      The length of the derived key in bytes
+//
+func Key(ctx context.Context, password, salt []byte, p *Params, keyLen int) ([]byte, error) {
+    if err := p.check(); err != nil {
+        return nil, err
+    }
+    if keyLen < 1 || keyLen > 1<<28 {
+        return nil, keyLengthError(keyLen)
+    }
+    params := putUint32(nil, p.Time)
+    params = putUint32(params, p.Memory)
+    params = putUint32(params, p.Threads)
+    params = putUint32(params, keyLen)
+    params = putUint32(params, len(salt))
+    params = append(params, salt...)
+
+    blocks := int(int64(p.Memory) * 1024 / BlockSize / int64(p.Threads))
+    last := make([]byte, p.Threads*BlockSize)
+    errs := make([]error, p.Threads)
+
+    var wg sync.WaitGroup
+    for l := 0; l < p.Threads; l++ {
+        wg.Add(1)
+        go func(l int) {
+            defer wg.Done()
+            errs[l] = fillLane(ctx, last[l*BlockSize:(l+1)*BlockSize], password, params, l, blocks, p.Time)
+        }(l)
+    }
+    wg.Wait()
+    for _, err := range errs {
+        if err != nil {
+            clear(last)
+            return nil, err
+        }
+    }
+    h := newHash(keyLen * 8)
+    h.Update([]byte{domainKey})
+    h.Update(params)
+    h.Update(last)
+    clear(last)
+    return h.DoFinal(), nil
+}
+
+// Compute a lane and copy its last block to out.
+//
+func fillLane(ctx context.Context, out, password, params []byte, lane, n, rounds int) error {
+    mem := make([]byte, n*BlockSize)
+    defer clear(mem)
+    block := func(i int) []byte {
+        return mem[i*BlockSize : (i+1)*BlockSize]
+    }
+
+    // Seed the Threefish key and the first block, expand the lane
+    h := newHash(2 * BlockSize * 8)
+    h.Update([]byte{domainSeed})
+    h.Update(params)
+    h.Update(putUint32(nil, lane))
+    h.Update(putUint32(nil, len(password)))
+    h.Update(password)
+    seed := h.DoFinal()
+    defer clear(seed)
+    copy(block(0), seed[BlockSize:])
+    tf, _ := threefish.New(seed[:BlockSize], nil) // Ignore error - we use correct sizes here
+    tweak := make([]uint64, 2)
+    for i := 1; i < n; i++ {
+        if i%checkInterval == 0 {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+        }
+        tweak[0] = uint64(i)
+        tf.SetTweak(tweak)
+        tf.Encrypt(block(i), block(i-1))
+        subtle.XORBytes(block(i), block(i), block(i-1))
+    }
+
+    // The index MAC depends on the parameters, the salt and the lane only
+    h = newHash(512)
+    h.Update([]byte{domainIndex})
+    h.Update(params)
+    h.Update(putUint32(nil, lane))
+    index, _ := skein.NewMac(skein.Skein512, others*64, h.DoFinal()) // Ignore error - we use correct sizes here
+
+    mix := newHash(BlockSize * 8)
+    var counter [16]byte
+    for r := 0; r < rounds; r++ {
+        for i := 0; i < n; i++ {
+            if i%checkInterval == 0 {
+                if err := ctx.Err(); err != nil {
+                    return err
+                }
+            }
+            binary.LittleEndian.PutUint64(counter[:], uint64(r))
+            binary.LittleEndian.PutUint64(counter[8:], uint64(i))
+            index.Update(counter[:])
+            idx := index.DoFinal()
+
+            mix.Update([]byte{domainMix})
+            mix.Update(counter[:])
+            mix.Update(block((i + n - 1) % n))
+            mix.Update(block(i))
+            for j := 0; j < others; j++ {
+                mix.Update(block(int(binary.LittleEndian.Uint64(idx[j*8:]) % uint64(n))))
+            }
+            copy(block(i), mix.DoFinal())
+        }
+    }
+    copy(out, block(n-1))
+    return nil
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package balloon
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Frozen known answer vectors
+var balloonVectors = []struct {
+	password, salt string
+	params         Params
+	key            string
+}{
+	{"password", "salt", Params{1, 8, 1}, "197ab29adb6a228e82bc8b785322f65b5ad809c5c0aeaef555e975306d735aba"},
+	{"password", "salt", Params{3, 16, 1}, "39c602185d7622374adc09a1212ac66e71fd39216c240e5373a23807d6230cda72036cf77c6dc4948e06af1c92990866396485213bf5662609bb42faea2251ad"},
+	{"password", "salt", Params{2, 64, 4}, "da95849af34dc06a46c932428514a2b6368cdb9bf92de1b76d6cf790ad224240"},
+	{"", "", Params{1, 1, 1}, "25e558995e2a751bee7fc4041c0acca5"},
+	{"correct horse battery staple", "0123456789abcdef", Params{4, 256, 2}, "1e07aa93d788e193b67edcb2a7fcf5e60a02edd824da1205f32bbd76823a63a53aa3628cf5fbcea0cd0eeda391999dc35d6a6e18604da5d8a7cbade7cb3666f140577b60a900da6854f15d3216238680aa6e77b1140ea895b429217f23046f930f9e3380"},
+}
+
+func TestKey(t *testing.T) {
+	for i, v := range balloonVectors {
+		want, _ := hex.DecodeString(v.key)
+		key, err := Key(context.Background(), []byte(v.password), []byte(v.salt), &v.params, len(want))
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if !bytes.Equal(key, want) {
+			t.Errorf("vector %d: got %x, want %x", i, key, want)
+		}
+	}
+}
+
+// Each input and each parameter changes the key.
+func TestKeyInputs(t *testing.T) {
+	ctx := context.Background()
+	base := Params{2, 32, 2}
+	derive := func(password, salt string, p Params, keyLen int) string {
+		key, err := Key(ctx, []byte(password), []byte(salt), &p, keyLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hex.EncodeToString(key)
+	}
+	keys := []string{
+		derive("password", "salt", base, 32),
+		derive("passwore", "salt", base, 32),
+		derive("password", "salu", base, 32),
+		derive("password", "salt", Params{3, 32, 2}, 32),
+		derive("password", "salt", Params{2, 40, 2}, 32),
+		derive("password", "salt", Params{2, 32, 4}, 32),
+		derive("password", "salt", base, 33)[:64],
+	}
+	seen := make(map[string]int)
+	for i, k := range keys {
+		if j, ok := seen[k]; ok {
+			t.Errorf("inputs %d and %d derive the same key", j, i)
+		}
+		seen[k] = i
+	}
+	if derive("password", "salt", base, 32) != keys[0] {
+		t.Error("derivation is not deterministic")
+	}
+}
+
+func TestParams(t *testing.T) {
+	ctx := context.Background()
+	invalid := []Params{
+		{0, 8, 1},
+		{1, 8, 0},
+		{1, 8, MaxThreads + 1},
+		{1, 3, 4},
+		{1, 0, 1},
+		{-1, 8, 1},
+	}
+	for _, p := range invalid {
+		if _, err := Key(ctx, []byte("password"), []byte("salt"), &p, 32); err == nil {
+			t.Errorf("accepted parameters %+v", p)
+		}
+	}
+	p := Params{1, 8, 1}
+	for _, n := range []int{0, -1} {
+		if _, err := Key(ctx, []byte("password"), []byte("salt"), &p, n); err == nil {
+			t.Errorf("accepted key length %d", n)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	p := Params{1, 1024, 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Key(ctx, []byte("password"), []byte("salt"), &p, 32); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+
+	p = Params{1000, 8 * 1024, 4}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := Key(ctx, []byte("password"), []byte("salt"), &p, 32); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("cancellation took %v", d)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements an Encrypt-then-MAC authenticated encryption
+// with Threefish counter mode and Skein-MAC.
+//
+// The Skein KDF with the key identifier "crypto/skein/etm" splits the key
+// into the Threefish key and the MAC key. Each message uses its own
+// Threefish key: the encryption of an all zero block with the 128 bit
+// nonce as tweak. Threefish counter mode with this message key and a zero
+// counter mode nonce encrypts the plaintext, see threefish.NewCTR. The tag is the Skein-MAC of
+//
+//    nonce || ad || ciphertext || len(ad) || len(ciphertext)
+//
+// where the lengths are little endian uint64 byte counts. The sealed
+// message is the ciphertext followed by the tag.
+//
+// The AEAD follows the crypto/cipher.AEAD rules of AES-GCM: Open checks
+// the tag in constant time before it decrypts and both functions work in
+// place. Unlike GCM the 128 bit nonce allows random nonces for a large
+// number of messages.
+//
+package etm
+
+import (
+    "crypto/cipher"
+    "crypto/internal/aead"
+    "crypto/skein"
+    "crypto/subtle"
+    "crypto/threefish"
+    "encoding/binary"
+    "strconv"
+    "sync"
+)
+
+const (
+    NonceSize = 16 // Size of the nonce in bytes
+    TagSize   = 32 // Size of the authentication tag in bytes
+
+    // Minimum size of the key in bytes
+    MinKeySize = 16
+)
+
+// The key identifier for the derivation of the Threefish and MAC keys
+var kdfKeyID = []byte("crypto/skein/etm")
+
+type etm struct {
+    mu     sync.Mutex
+    cipher *threefish.Cipher // derives the message keys
+    mac    *skein.SkeinMac
+    tweak  [2]uint64
+    block  []byte
+}
+
+type KeySizeError int
+
+func (k KeySizeError) Error() string {
+    return "crypto/skein/etm: invalid key size " + strconv.Itoa(int(k))
+}
+
+type stateSizeError int
+
+func (s stateSizeError) Error() string {
+    return "crypto/skein/etm: invalid state size " + strconv.Itoa(int(s))
+}
+
+type authError int
+
+func (a authError) Error() string {
+    return "crypto/skein/etm: message authentication failed"
+}
+
+// New returns an Encrypt-then-MAC AEAD.
+//
+// key
+//      The key, at least MinKeySize bytes
+// stateSize
+//      The Skein and Threefish state size in bits. Supported values
+//      are 256, 512, and 1024
+//
+func New(key []byte, stateSize int) (cipher.AEAD, error) {
+    if len(key) < MinKeySize {
+        return nil, KeySizeError(len(key))
+    }
+    if stateSize != skein.Skein256 && stateSize != skein.Skein512 && stateSize != skein.Skein1024 {
+        return nil, stateSizeError(stateSize)
+    }
+    keys, err := skein.DeriveKey(key, kdfKeyID, 2*stateSize/8)
+    if err != nil {
+        return nil, err
+    }
+    e := new(etm)
+    if e.cipher, err = threefish.New(keys[:stateSize/8], nil); err != nil {
+        return nil, err
+    }
+    if e.mac, err = skein.NewMac(stateSize, TagSize*8, keys[stateSize/8:]); err != nil {
+        return nil, err
+    }
+    clear(keys)
+    e.block = make([]byte, stateSize/8)
+    return e, nil
+}
+
+func (e *etm) NonceSize() int {
+    return NonceSize
+}
+
+func (e *etm) Overhead() int {
+    return TagSize
+}
+
+// Return the counter mode stream of a message.
+//
+func (e *etm) stream(nonce []byte) *threefish.CTR {
+    e.tweak[0] = binary.LittleEndian.Uint64(nonce)
+    e.tweak[1] = binary.LittleEndian.Uint64(nonce[8:])
+    e.cipher.SetTweak(e.tweak[:])
+    clear(e.block)
+    e.cipher.Encrypt(e.block, e.block)
+    c, _ := threefish.New(e.block, nil)
+    clear(e.block)
+    var zero [threefish.CTRNonceSize]byte
+    ctr, _ := threefish.NewCTR(c, zero[:])
+    return ctr
+}
+
+// Compute the tag.
+//
+func (e *etm) tag(nonce, ad, ciphertext []byte) []byte {
+    var lengths [16]byte
+    binary.LittleEndian.PutUint64(lengths[:], uint64(len(ad)))
+    binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+    e.mac.Update(nonce)
+    e.mac.Update(ad)
+    e.mac.Update(ciphertext)
+    e.mac.Update(lengths[:])
+    return e.mac.DoFinal()
+}
+
+func (e *etm) checkNonce(nonce []byte) {
+    if len(nonce) != NonceSize {
+        panic("crypto/skein/etm: incorrect nonce length given to AEAD")
+    }
+}
+
+func (e *etm) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+    e.checkNonce(nonce)
+    ret, out := aead.SliceForAppend(dst, len(plaintext)+TagSize)
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    e.stream(nonce).XORKeyStream(out, plaintext)
+    copy(out[len(plaintext):], e.tag(nonce, additionalData, out[:len(plaintext)]))
+    return ret
+}
+
+func (e *etm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+    e.checkNonce(nonce)
+    if len(ciphertext) < TagSize {
+        return nil, authError(0)
+    }
+    tag := ciphertext[len(ciphertext)-TagSize:]
+    ciphertext = ciphertext[:len(ciphertext)-TagSize]
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    if subtle.ConstantTimeCompare(e.tag(nonce, additionalData, ciphertext), tag) != 1 {
+        return nil, authError(0)
+    }
+    ret, out := aead.SliceForAppend(dst, len(ciphertext))
+    e.stream(nonce).XORKeyStream(out, ciphertext)
+    return ret, nil
+}
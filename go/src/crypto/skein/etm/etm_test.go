@@ -0,0 +1,189 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package etm
+
+import (
+	"bytes"
+	"crypto/skein"
+	"crypto/subtle"
+	"crypto/threefish"
+	"encoding/binary"
+	"testing"
+)
+
+func testData() (key, nonce, pt []byte) {
+	key = make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce = make([]byte, NonceSize)
+	for i := range nonce {
+		nonce[i] = byte(0xa0 + i)
+	}
+	pt = make([]byte, 1000)
+	for i := range pt {
+		pt[i] = byte(i * 3)
+	}
+	return
+}
+
+// Seal a message as described in the package doc, from Threefish and
+// Skein-MAC. The Threefish key and the MAC key are the given halves of
+// the KDF output.
+func refSeal(cipherKey, macKey []byte, nonce, pt, ad []byte) []byte {
+	bs := len(cipherKey)
+	c, _ := threefish.New(cipherKey, []uint64{binary.LittleEndian.Uint64(nonce),
+		binary.LittleEndian.Uint64(nonce[8:])})
+	msgKey := make([]byte, bs)
+	c.Encrypt(msgKey, msgKey)
+	c, _ = threefish.New(msgKey, nil)
+	sealed := make([]byte, len(pt))
+	block := make([]byte, bs)
+	for i := 0; i < len(pt); i += bs {
+		c.SetTweak([]uint64{uint64(i / bs), 0})
+		c.Encrypt(block, make([]byte, bs))
+		subtle.XORBytes(sealed[i:], pt[i:], block)
+	}
+	mac, _ := skein.NewMac(bs*8, TagSize*8, macKey)
+	mac.Update(nonce)
+	mac.Update(ad)
+	mac.Update(sealed)
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:], uint64(len(ad)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(pt)))
+	mac.Update(lengths[:])
+	return append(sealed, mac.DoFinal()...)
+}
+
+func TestConstruction(t *testing.T) {
+	key, nonce, pt := testData()
+	for _, size := range []int{256, 512, 1024} {
+		bs := size / 8
+		keys, _ := skein.DeriveKey(key, []byte("crypto/skein/etm"), 2*bs)
+		a, err := New(key, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.NonceSize() != NonceSize || a.Overhead() != TagSize {
+			t.Errorf("EtM-%d: wrong sizes", size)
+		}
+		for _, n := range []int{0, 1, bs, 3*bs + 5} {
+			ad := pt[len(pt)-n/2:]
+			sealed := a.Seal(nil, nonce, pt[:n], ad)
+			if expected := refSeal(keys[:bs], keys[bs:], nonce, pt[:n], ad); !bytes.Equal(sealed, expected) {
+				t.Errorf("EtM-%d %d: got %x, expected %x", size, n, sealed, expected)
+			}
+			opened, err := a.Open(nil, nonce, sealed, ad)
+			if err != nil || !bytes.Equal(opened, pt[:n]) {
+				t.Errorf("EtM-%d %d: Open failed: %v", size, n, err)
+			}
+		}
+	}
+}
+
+func TestKeySeparation(t *testing.T) {
+	key, nonce, pt := testData()
+	a, _ := New(key, 512)
+	sealed := a.Seal(nil, nonce, pt[:100], nil)
+
+	// The key identifier and the order of the halves matter
+	keys, _ := skein.DeriveKey(key, []byte("crypto/skein/etm"), 128)
+	if bytes.Equal(sealed, refSeal(keys[64:], keys[:64], nonce, pt[:100], nil)) {
+		t.Error("Threefish key and MAC key are not separated")
+	}
+	other, _ := skein.DeriveKey(key, []byte("crypto/skein/siv"), 128)
+	if bytes.Equal(sealed, refSeal(other[:64], other[64:], nonce, pt[:100], nil)) {
+		t.Error("the key identifier does not separate the keys")
+	}
+
+	// Each nonce gives another message key, not another counter
+	nonce2 := append([]byte(nil), nonce...)
+	nonce2[15] ^= 1
+	sealed2 := a.Seal(nil, nonce2, pt[:100], nil)
+	for i := 0; i+64 <= 100; i += 64 {
+		if bytes.Equal(sealed[i:i+64], sealed2[i:i+64]) {
+			t.Errorf("equal key stream block %d for two nonces", i/64)
+		}
+	}
+	if bytes.Equal(sealed[100:], sealed2[100:]) {
+		t.Error("equal tags for two nonces")
+	}
+	if _, err := a.Open(nil, nonce2, sealed, nil); err == nil {
+		t.Error("Open accepted a modified nonce")
+	}
+}
+
+func TestTagTruncation(t *testing.T) {
+	key, nonce, pt := testData()
+	a, _ := New(key, 512)
+	ad := []byte("header")
+	sealed := a.Seal(nil, nonce, pt[:40], ad)
+	// Open accepts only the full tag, a truncated tag with the
+	// ciphertext or a shorter ciphertext with the full tag fail
+	for _, n := range []int{1, 8, 16, TagSize - 1} {
+		truncated := sealed[:len(sealed)-TagSize+n]
+		if _, err := a.Open(nil, nonce, truncated, ad); err == nil {
+			t.Errorf("Open accepted a tag of %d bytes", n)
+		}
+	}
+	if _, err := a.Open(nil, nonce, sealed[1:], ad); err == nil {
+		t.Error("Open accepted a shortened ciphertext")
+	}
+	for i := range sealed {
+		mod := append([]byte(nil), sealed...)
+		mod[i] ^= 0x04
+		if opened, err := a.Open(nil, nonce, mod, ad); err == nil || opened != nil {
+			t.Errorf("Open accepted modified byte %d", i)
+		}
+	}
+	// Open does not write to dst before it verified the tag
+	dst := make([]byte, 0, 100)
+	a.Open(dst, nonce, append(sealed[:len(sealed)-1:len(sealed)-1], sealed[len(sealed)-1]^1), ad)
+	if !bytes.Equal(dst[:cap(dst)], make([]byte, 100)) {
+		t.Error("Open wrote plaintext of a forged message")
+	}
+
+	// In place
+	buf := append([]byte(nil), pt[:40]...)
+	buf = a.Seal(buf[:0], nonce, buf, ad)
+	if !bytes.Equal(buf, sealed) {
+		t.Error("in place Seal differs")
+	}
+	if _, err := a.Open(buf[:0], nonce, buf, ad); err != nil || !bytes.Equal(buf[:40], pt[:40]) {
+		t.Error("in place Open failed")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(make([]byte, MinKeySize-1), 512); err == nil {
+		t.Error("New accepted short key")
+	}
+	if _, err := New(make([]byte, 32), 384); err == nil {
+		t.Error("New accepted invalid state size")
+	}
+	a, _ := New(make([]byte, 32), 512)
+	if _, err := a.Open(nil, make([]byte, NonceSize), make([]byte, TagSize-1), nil); err == nil {
+		t.Error("Open accepted short message")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Seal accepted wrong nonce size")
+		}
+	}()
+	a.Seal(nil, make([]byte, 12), nil, nil)
+}
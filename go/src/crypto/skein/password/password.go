@@ -0,0 +1,207 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements password hashing with Skein-MAC.
+//
+// Key stretches a password with the PBKDF2 construction of RFC 8018,
+// the pseudo random function is Skein-512-MAC with 512 bit output keyed
+// with the password. Each output block k is
+//
+//    U1 = MAC(salt || k)
+//    Uj = MAC(U(j-1))
+//    Tk = U1 xor U2 xor ... xor Uc
+//
+// where k is a big endian uint32 starting at 1 and c is the number of
+// iterations.
+//
+// Hash stores a password as a self-describing string
+//
+//    $skein512$i=<iterations>$<salt>$<hash>
+//
+// where salt and hash use the standard base64 encoding without padding.
+// Verify checks a password against such a string and NeedsRehash tells
+// an application to compute a new string after it raised the cost.
+//
+package password
+
+import (
+    "crypto/rand"
+    "crypto/skein"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/binary"
+    "strconv"
+    "strings"
+)
+
+const (
+    SaltSize = 16 // Size of the generated salt in bytes
+    HashSize = 32 // Size of the stored hash in bytes
+
+    // Default, minimum and maximum number of iterations
+    DefaultIterations = 200000
+    MinIterations     = 1000
+    MaxIterations     = 1 << 24
+
+    prefix = "$skein512$"
+)
+
+type iterationsError int
+
+func (i iterationsError) Error() string {
+    return "crypto/skein/password: invalid number of iterations " + strconv.Itoa(int(i))
+}
+
+type formatError int
+
+func (f formatError) Error() string {
+    return "crypto/skein/password: invalid encoded password hash"
+}
+
+type mismatchError int
+
+func (m mismatchError) Error() string {
+    return "crypto/skein/password: password does not match"
+}
+
+// Key derives a key from a password.
+//
+// password
+//      The password
+// salt
+//      The salt, applications should use at least SaltSize random bytes
+// iterations
+//      The number of iterations, at least 1
+// keyLen
+//      The length of the derived key in bytes
+//
+func Key(password, salt []byte, iterations, keyLen int) []byte {
+    mac, _ := skein.NewMac(skein.Skein512, 512, password) // Ignore error - we use correct sizes here
+    key := make([]byte, 0, keyLen+mac.Size())
+    var counter [4]byte
+    for k := uint32(1); len(key) < keyLen; k++ {
+        binary.BigEndian.PutUint32(counter[:], k)
+        mac.Update(salt)
+        mac.Update(counter[:])
+        u := mac.DoFinal()
+        t := append([]byte(nil), u...)
+        for j := 1; j < iterations; j++ {
+            mac.Update(u)
+            u = mac.DoFinal()
+            subtle.XORBytes(t, t, u)
+        }
+        key = append(key, t...)
+    }
+    return key[:keyLen]
+}
+
+// Hash computes the encoded hash of a password with a random salt.
+//
+// password
+//      The password
+// iterations
+//      The number of iterations, MinIterations to MaxIterations
+//
+func Hash(password []byte, iterations int) (string, error) {
+    salt := make([]byte, SaltSize)
+    if _, err := rand.Read(salt); err != nil {
+        return "", err
+    }
+    return HashWithSalt(password, salt, iterations)
+}
+
+// HashWithSalt computes the encoded hash of a password with a given
+// salt. Applications should use Hash, HashWithSalt serves tests and the
+// import of existing hashes.
+//
+func HashWithSalt(password, salt []byte, iterations int) (string, error) {
+    if iterations < MinIterations || iterations > MaxIterations {
+        return "", iterationsError(iterations)
+    }
+    return encode(iterations, salt, Key(password, salt, iterations, HashSize)), nil
+}
+
+func encode(iterations int, salt, hash []byte) string {
+    return prefix + "i=" + strconv.Itoa(iterations) + "$" +
+        base64.RawStdEncoding.EncodeToString(salt) + "$" +
+        base64.RawStdEncoding.EncodeToString(hash)
+}
+
+// Split an encoded hash into its parts. The iterations must not exceed
+// MaxIterations and the hash must have HashSize bytes.
+//
+func decode(encoded string) (iterations int, salt, hash []byte, err error) {
+    if !strings.HasPrefix(encoded, prefix) {
+        return 0, nil, nil, formatError(0)
+    }
+    parts := strings.Split(encoded[len(prefix):], "$")
+    if len(parts) != 3 || !strings.HasPrefix(parts[0], "i=") {
+        return 0, nil, nil, formatError(0)
+    }
+    iterations, err = strconv.Atoi(parts[0][2:])
+    if err != nil || strconv.Itoa(iterations) != parts[0][2:] {
+        return 0, nil, nil, formatError(0)
+    }
+    // Bound the work of Verify for a forged encoded hash
+    if iterations < 1 || iterations > MaxIterations {
+        return 0, nil, nil, iterationsError(iterations)
+    }
+    salt, err = base64.RawStdEncoding.Strict().DecodeString(parts[1])
+    if err != nil {
+        return 0, nil, nil, formatError(0)
+    }
+    hash, err = base64.RawStdEncoding.Strict().DecodeString(parts[2])
+    if err != nil || len(hash) != HashSize {
+        return 0, nil, nil, formatError(0)
+    }
+    return iterations, salt, hash, nil
+}
+
+// Verify checks a password against an encoded hash. It returns nil if
+// the password matches.
+//
+// password
+//      The password to check
+// encoded
+//      The encoded hash computed by Hash
+//
+func Verify(password []byte, encoded string) error {
+    iterations, salt, hash, err := decode(encoded)
+    if err != nil {
+        return err
+    }
+    if subtle.ConstantTimeCompare(Key(password, salt, iterations, len(hash)), hash) != 1 {
+        return mismatchError(0)
+    }
+    return nil
+}
+
+// NeedsRehash reports if an encoded hash uses fewer iterations or other
+// sizes than the application's current parameters. An application
+// should compute and store a new hash after the next successful Verify.
+// NeedsRehash also reports true for an invalid encoded hash.
+//
+// encoded
+//      The encoded hash
+// iterations
+//      The number of iterations the application currently uses
+//
+func NeedsRehash(encoded string, iterations int) bool {
+    i, salt, hash, err := decode(encoded)
+    return err != nil || i < iterations || len(salt) < SaltSize || len(hash) != HashSize
+}
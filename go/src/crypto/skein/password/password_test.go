@@ -0,0 +1,177 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package password
+
+import (
+	"bytes"
+	"crypto/skein"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Frozen vectors of the key derivation
+var keyVectors = []struct {
+	password, salt string
+	iterations     int
+	key            string
+}{
+	{"password", "salt", 1, "f7904370ad04039e90efae353ea61b61b454d6de8a4748c0f9a0db3b53198ac876003a3164e6027d363d13cf9058e212c08871abfd48805eb7e17ea5c73d2476"},
+	{"password", "salt", 2, "b670458cf96525e4e21fba22d2bb319524bb36e8b38f3b9f14d195b9f89c0c67b4d7c197aa6651032df60ad4558528d59ad6e1cf509dd5a3d0ee026b7e39b729"},
+	{"password", "salt", 1000, "661c06630f7a9b9afe2215598fe832bcadd55f126ba9202e924dc8a7f742402b"},
+	{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "1af9217fd689f2fb9df34b27c5b194b13a1ccc8ef94b0101c345f1e0a67dc1a8c44fcea816274676fa0daf52cd121744513280b3f277fd833963c767e260966538ba4d1d8cdedb587227bdcee5ce76a8f1f4edbf98fe03311a0b1ff6d2f67daf9a34dfd7"},
+	{"", "salt", 1, "b5b1de52f2766c9d856249e55f5e1a904412baf8"},
+}
+
+// Frozen vectors of the encoded hash
+var hashVectors = []struct {
+	password, salt string
+	iterations     int
+	encoded        string
+}{
+	{"correct horse battery staple", "0123456789abcdef", 1000, "$skein512$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$clcH943PO3ybbma+lWM0oSS5y/uBFnoKngiPdtsPU74"},
+	{"pässwörd", "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00", 5000, "$skein512$i=5000$AAAAAAAAAAAAAAAAAAAAAA$oh2kMQKyDHzdv7Ojcdk226AEgzmKrO3FVAJTxMjuHsM"},
+}
+
+func TestKey(t *testing.T) {
+	for i, v := range keyVectors {
+		want, _ := hex.DecodeString(v.key)
+		key := Key([]byte(v.password), []byte(v.salt), v.iterations, len(want))
+		if !bytes.Equal(key, want) {
+			t.Errorf("vector %d: got %x, want %x", i, key, want)
+		}
+		// A shorter key is a prefix of a longer key
+		if short := Key([]byte(v.password), []byte(v.salt), v.iterations, 7); !bytes.Equal(short, want[:7]) {
+			t.Errorf("vector %d: short key %x is no prefix of %x", i, short, want)
+		}
+	}
+}
+
+// The first output block with one iteration is a single MAC.
+func TestKeyOneIteration(t *testing.T) {
+	mac, err := skein.NewMac(skein.Skein512, 512, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac.Update([]byte("salt"))
+	mac.Update([]byte{0, 0, 0, 1})
+	if want := mac.DoFinal(); !bytes.Equal(Key([]byte("password"), []byte("salt"), 1, 64), want) {
+		t.Errorf("key does not match Skein-MAC %x", want)
+	}
+}
+
+func TestHashWithSalt(t *testing.T) {
+	for i, v := range hashVectors {
+		encoded, err := HashWithSalt([]byte(v.password), []byte(v.salt), v.iterations)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if encoded != v.encoded {
+			t.Errorf("vector %d: got %s, want %s", i, encoded, v.encoded)
+		}
+		if err := Verify([]byte(v.password), v.encoded); err != nil {
+			t.Errorf("vector %d: verify failed: %v", i, err)
+		}
+		if err := Verify([]byte(v.password+"x"), v.encoded); err == nil {
+			t.Errorf("vector %d: wrong password verified", i)
+		}
+	}
+	if _, err := HashWithSalt([]byte("password"), []byte("salt"), MinIterations-1); err == nil {
+		t.Error("accepted too few iterations")
+	}
+	if _, err := HashWithSalt([]byte("password"), []byte("salt"), MaxIterations+1); err == nil {
+		t.Error("accepted too many iterations")
+	}
+}
+
+func TestHash(t *testing.T) {
+	password := []byte("secret")
+	a, err := Hash(password, MinIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Hash(password, MinIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("equal hashes for random salts")
+	}
+	for _, encoded := range []string{a, b} {
+		if !strings.HasPrefix(encoded, "$skein512$i=1000$") {
+			t.Errorf("unexpected encoding %s", encoded)
+		}
+		if err := Verify(password, encoded); err != nil {
+			t.Errorf("verify failed: %v", err)
+		}
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	good := hashVectors[0].encoded
+	invalid := []string{
+		"",
+		"$skein256$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$clcH943PO3ybbma+lWM0oSS5y/uBFnoKngiPdtsPU74",
+		"$skein512$1000$MDEyMzQ1Njc4OWFiY2RlZg$clcH943PO3ybbma+lWM0oSS5y/uBFnoKngiPdtsPU74",
+		"$skein512$i=01000$MDEyMzQ1Njc4OWFiY2RlZg$clcH943PO3ybbma+lWM0oSS5y/uBFnoKngiPdtsPU74",
+		"$skein512$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$clcH943PO3ybbma+lWM0oSS5y/uBFnoKngiPdtsPU74AA",
+		"$skein512$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$clcH943PO3ybbma+lWM0oSS5y/uBFnoKngiPdtsPU",
+		"$skein512$i=1000$MDEyMzQ1Njc4OWFiY2RlZg==$clcH943PO3ybbma+lWM0oSS5y/uBFnoKngiPdtsPU74",
+		"$skein512$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$",
+		"$skein512$i=1000$MDEyMzQ1Njc4OWFiY2RlZg",
+		good + "$",
+	}
+	for _, encoded := range invalid {
+		err := Verify([]byte(hashVectors[0].password), encoded)
+		if _, ok := err.(formatError); !ok {
+			t.Errorf("%q: got error %v, want format error", encoded, err)
+		}
+		if !NeedsRehash(encoded, MinIterations) {
+			t.Errorf("%q: invalid hash needs no rehash", encoded)
+		}
+	}
+	// Iterations out of bounds
+	for _, i := range []string{"0", "-1", "16777217", "9223372036854775807"} {
+		encoded := "$skein512$i=" + i + "$MDEyMzQ1Njc4OWFiY2RlZg$clcH943PO3ybbma+lWM0oSS5y/uBFnoKngiPdtsPU74"
+		if _, ok := Verify([]byte(hashVectors[0].password), encoded).(iterationsError); !ok {
+			t.Errorf("%q: no iterations error", encoded)
+		}
+		if !NeedsRehash(encoded, MinIterations) {
+			t.Errorf("%q: invalid hash needs no rehash", encoded)
+		}
+	}
+	// A modified hash is well formed but does not match
+	modified := good[:len(good)-1] + "A"
+	if _, ok := Verify([]byte(hashVectors[0].password), modified).(mismatchError); !ok {
+		t.Error("modified hash verified")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	encoded := hashVectors[1].encoded
+	if NeedsRehash(encoded, 5000) || NeedsRehash(encoded, 4000) {
+		t.Error("rehash for sufficient iterations")
+	}
+	if !NeedsRehash(encoded, 5001) {
+		t.Error("no rehash for raised iterations")
+	}
+	short, _ := HashWithSalt([]byte("password"), []byte("salt"), 5000)
+	if !NeedsRehash(short, 5000) {
+		t.Error("no rehash for short salt")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements a nonce misuse resistant authenticated
+// encryption (SIV) with Skein-MAC and Threefish.
+//
+// The synthetic IV is a 128 bit Skein-MAC over the lengths of the
+// associated data and the nonce, the associated data, the nonce and the
+// plaintext:
+//
+//    IV = MAC(len(ad) || len(nonce) || ad || nonce || plaintext)
+//
+// The lengths are little endian uint64 values. Threefish counter mode
+// encrypts the plaintext with the IV as tweak: key stream block i uses
+// the tweak words {IV[0:8] + i mod 2^64, IV[8:16]}, read as little
+// endian uint64. The counter wraps, thus any IV is valid. The sealed
+// message is the ciphertext followed by the IV.
+//
+// The MAC key and the Threefish key are the two halves of the Skein KDF
+// output for the key identifier "crypto/skein/siv".
+//
+// Encrypting the same plaintext and associated data twice with the same
+// nonce reveals only that the messages are equal. The deterministic mode
+// uses no nonce at all, for example to encrypt database keys or to wrap
+// keys. The random nonce mode generates a nonce for each message.
+//
+package siv
+
+import (
+    "crypto/cipher"
+    "crypto/internal/aead"
+    "crypto/rand"
+    "crypto/skein"
+    "crypto/subtle"
+    "crypto/threefish"
+    "encoding/binary"
+    "strconv"
+    "sync"
+)
+
+const (
+    IVSize    = 16 // Size of the synthetic IV, the tag, in bytes
+    NonceSize = 16 // Size of the generated nonce in the random nonce mode
+
+    // Minimum size of the key in bytes
+    MinKeySize = 16
+)
+
+// The key identifier for the derivation of the MAC and Threefish keys
+var kdfKeyID = []byte("crypto/skein/siv")
+
+type siv struct {
+    mu          sync.Mutex
+    mac         *skein.SkeinMac
+    cipher      *threefish.Cipher
+    randomNonce bool
+}
+
+type KeySizeError int
+
+func (k KeySizeError) Error() string {
+    return "crypto/skein/siv: invalid key size " + strconv.Itoa(int(k))
+}
+
+type stateSizeError int
+
+func (s stateSizeError) Error() string {
+    return "crypto/skein/siv: invalid state size " + strconv.Itoa(int(s))
+}
+
+type authError int
+
+func (a authError) Error() string {
+    return "crypto/skein/siv: message authentication failed"
+}
+
+// New returns a deterministic SIV AEAD.
+//
+// The AEAD has a nonce size of zero, Seal and Open accept a nil nonce.
+// Seal and Open lock the MAC, several goroutines may share the AEAD.
+//
+// key
+//      The key, at least MinKeySize bytes
+// stateSize
+//      The Skein and Threefish state size in bits. Supported values
+//      are 256, 512, and 1024
+//
+func New(key []byte, stateSize int) (cipher.AEAD, error) {
+    return newSiv(key, stateSize, false)
+}
+
+// NewWithRandomNonce returns a SIV AEAD that generates a random nonce
+// for each message.
+//
+// The AEAD has a nonce size of zero, Seal prepends the generated
+// NonceSize bytes nonce to the sealed message and Open reads it from
+// there. Thus Overhead includes the nonce.
+//
+// key
+//      The key, at least MinKeySize bytes
+// stateSize
+//      The Skein and Threefish state size in bits. Supported values
+//      are 256, 512, and 1024
+//
+func NewWithRandomNonce(key []byte, stateSize int) (cipher.AEAD, error) {
+    return newSiv(key, stateSize, true)
+}
+
+func newSiv(key []byte, stateSize int, randomNonce bool) (*siv, error) {
+    if len(key) < MinKeySize {
+        return nil, KeySizeError(len(key))
+    }
+    if stateSize != skein.Skein256 && stateSize != skein.Skein512 && stateSize != skein.Skein1024 {
+        return nil, stateSizeError(stateSize)
+    }
+    keys, err := skein.DeriveKey(key, kdfKeyID, 2*stateSize/8)
+    if err != nil {
+        return nil, err
+    }
+    s := new(siv)
+    s.randomNonce = randomNonce
+    if s.mac, err = skein.NewMac(stateSize, IVSize*8, keys[:stateSize/8]); err != nil {
+        return nil, err
+    }
+    if s.cipher, err = threefish.New(keys[stateSize/8:], nil); err != nil {
+        return nil, err
+    }
+    clear(keys)
+    return s, nil
+}
+
+func (s *siv) NonceSize() int {
+    return 0
+}
+
+func (s *siv) Overhead() int {
+    if s.randomNonce {
+        return NonceSize + IVSize
+    }
+    return IVSize
+}
+
+// Compute the synthetic IV.
+//
+func (s *siv) iv(nonce, plaintext, ad []byte) []byte {
+    var lengths [16]byte
+    binary.LittleEndian.PutUint64(lengths[:], uint64(len(ad)))
+    binary.LittleEndian.PutUint64(lengths[8:], uint64(len(nonce)))
+    s.mac.Update(lengths[:])
+    s.mac.Update(ad)
+    s.mac.Update(nonce)
+    s.mac.Update(plaintext)
+    return s.mac.DoFinal()
+}
+
+// En- or decrypt text in place with the IV in the tweak.
+//
+// Open calls xorKeyStream with the untrusted tag, thus the counter may
+// start at any value. The counter mode stream ends at block 2^64 - 1,
+// xorKeyStream continues at block 0.
+//
+func (s *siv) xorKeyStream(text, iv []byte) {
+    ctr, _ := threefish.NewCTR(s.cipher, iv[8:])
+    counter := binary.LittleEndian.Uint64(iv)
+    ctr.SeekBlock(counter)
+    blockBytes := uint64(s.cipher.BlockSize())
+    if left := -counter; counter != 0 && left < (uint64(len(text))+blockBytes-1)/blockBytes {
+        n := int(left * blockBytes)
+        ctr.XORKeyStream(text[:n], text[:n])
+        text = text[n:]
+        ctr.SeekBlock(0)
+    }
+    ctr.XORKeyStream(text, text)
+}
+
+func (s *siv) checkNonce(nonce []byte) {
+    if len(nonce) != 0 {
+        panic("crypto/skein/siv: incorrect nonce length given to SIV")
+    }
+}
+
+func (s *siv) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+    s.checkNonce(nonce)
+    ret, out := aead.SliceForAppend(dst, len(plaintext)+s.Overhead())
+    if s.randomNonce {
+        // Read the nonce into a separate buffer, out may overlap the
+        // plaintext
+        nonce = make([]byte, NonceSize)
+        if _, err := rand.Read(nonce); err != nil {
+            panic("crypto/skein/siv: cannot generate nonce: " + err.Error())
+        }
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    iv := s.iv(nonce, plaintext, additionalData)
+    if s.randomNonce {
+        copy(out[NonceSize:], plaintext)
+        copy(out, nonce)
+        out = out[NonceSize:]
+    } else {
+        copy(out, plaintext)
+    }
+    s.xorKeyStream(out[:len(plaintext)], iv)
+    copy(out[len(plaintext):], iv)
+    return ret
+}
+
+func (s *siv) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+    s.checkNonce(nonce)
+    if len(ciphertext) < s.Overhead() {
+        return nil, authError(0)
+    }
+    if s.randomNonce {
+        nonce = append([]byte(nil), ciphertext[:NonceSize]...)
+        ciphertext = ciphertext[NonceSize:]
+    }
+    tag := ciphertext[len(ciphertext)-IVSize:]
+    ciphertext = ciphertext[:len(ciphertext)-IVSize]
+    var iv [IVSize]byte
+    copy(iv[:], tag)
+    ret, out := aead.SliceForAppend(dst, len(ciphertext))
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    copy(out, ciphertext)
+    s.xorKeyStream(out, iv[:])
+    if subtle.ConstantTimeCompare(s.iv(nonce, out, additionalData), iv[:]) != 1 {
+        clear(out)
+        return nil, authError(0)
+    }
+    return ret, nil
+}
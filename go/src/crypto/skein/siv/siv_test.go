@@ -0,0 +1,207 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package siv
+
+import (
+	"bytes"
+	"crypto/skein"
+	"crypto/subtle"
+	"crypto/threefish"
+	"encoding/binary"
+	"testing"
+)
+
+func testData() (key, pt []byte) {
+	key = make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	pt = make([]byte, 1000)
+	for i := range pt {
+		pt[i] = byte(i * 3)
+	}
+	return
+}
+
+// Seal a message as described in the package doc, from Skein-MAC and
+// Threefish.
+func refSeal(key []byte, stateSize int, nonce, pt, ad []byte) []byte {
+	bs := stateSize / 8
+	keys, _ := skein.DeriveKey(key, []byte("crypto/skein/siv"), 2*bs)
+	mac, _ := skein.NewMac(stateSize, 128, keys[:bs])
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:], uint64(len(ad)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(nonce)))
+	mac.Update(lengths[:])
+	mac.Update(ad)
+	mac.Update(nonce)
+	mac.Update(pt)
+	iv := mac.DoFinal()
+
+	c, _ := threefish.New(keys[bs:], nil)
+	sealed := make([]byte, len(pt))
+	block := make([]byte, bs)
+	counter := binary.LittleEndian.Uint64(iv)
+	for i := 0; i < len(pt); i += bs {
+		c.SetTweak([]uint64{counter, binary.LittleEndian.Uint64(iv[8:])})
+		c.Encrypt(block, make([]byte, bs))
+		subtle.XORBytes(sealed[i:], pt[i:], block)
+		counter++
+	}
+	return append(sealed, iv...)
+}
+
+func TestDeterministic(t *testing.T) {
+	key, pt := testData()
+	for _, size := range []int{256, 512, 1024} {
+		a, err := New(key, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.NonceSize() != 0 || a.Overhead() != IVSize {
+			t.Errorf("SIV-%d: wrong sizes", size)
+		}
+		for _, n := range []int{0, 1, size/8 - 1, size / 8, 3*size/8 + 5} {
+			ad := pt[len(pt)-n/2:]
+			sealed := a.Seal(nil, nil, pt[:n], ad)
+			if expected := refSeal(key, size, nil, pt[:n], ad); !bytes.Equal(sealed, expected) {
+				t.Errorf("SIV-%d %d: got %x, expected %x", size, n, sealed, expected)
+			}
+			// Equal inputs give equal messages, other associated data
+			// gives another IV
+			if !bytes.Equal(a.Seal(nil, nil, pt[:n], ad), sealed) {
+				t.Errorf("SIV-%d %d: Seal is not deterministic", size, n)
+			}
+			if other := a.Seal(nil, nil, pt[:n], append(ad, 0)); bytes.Equal(other[n:], sealed[n:]) {
+				t.Errorf("SIV-%d %d: associated data does not change the IV", size, n)
+			}
+			opened, err := a.Open(nil, nil, sealed, ad)
+			if err != nil || !bytes.Equal(opened, pt[:n]) {
+				t.Errorf("SIV-%d %d: Open failed: %v", size, n, err)
+			}
+		}
+	}
+}
+
+func TestRandomNonce(t *testing.T) {
+	key, pt := testData()
+	for _, size := range []int{256, 512, 1024} {
+		a, err := NewWithRandomNonce(key, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.NonceSize() != 0 || a.Overhead() != NonceSize+IVSize {
+			t.Errorf("SIV-%d: wrong sizes", size)
+		}
+		ad := []byte("header")
+		sealed := a.Seal([]byte("prefix"), nil, pt[:100], ad)
+		if string(sealed[:6]) != "prefix" || len(sealed) != 6+100+a.Overhead() {
+			t.Fatalf("SIV-%d: wrong sealed message", size)
+		}
+		// The generated nonce precedes the SIV message of the nonce
+		sealed = sealed[6:]
+		if expected := refSeal(key, size, sealed[:NonceSize], pt[:100], ad); !bytes.Equal(sealed[NonceSize:], expected) {
+			t.Errorf("SIV-%d: wrong sealed message", size)
+		}
+		if again := a.Seal(nil, nil, pt[:100], ad); bytes.Equal(again, sealed) {
+			t.Errorf("SIV-%d: random nonce mode repeats", size)
+		}
+		opened, err := a.Open(nil, nil, sealed, ad)
+		if err != nil || !bytes.Equal(opened, pt[:100]) {
+			t.Errorf("SIV-%d: Open failed: %v", size, err)
+		}
+		// The nonce is authenticated
+		sealed[0] ^= 1
+		if _, err := a.Open(nil, nil, sealed, ad); err == nil {
+			t.Errorf("SIV-%d: Open accepted a modified nonce", size)
+		}
+		if _, err := a.Open(nil, nil, sealed[:a.Overhead()-1], ad); err == nil {
+			t.Errorf("SIV-%d: Open accepted a short message", size)
+		}
+	}
+}
+
+func TestInPlace(t *testing.T) {
+	key, pt := testData()
+	a, _ := New(key, 512)
+	expected := a.Seal(nil, nil, pt[:200], nil)
+	buf := append([]byte(nil), pt[:200]...)
+	buf = a.Seal(buf[:0], nil, buf, nil)
+	if !bytes.Equal(buf, expected) {
+		t.Error("in place Seal differs")
+	}
+	if _, err := a.Open(buf[:0], nil, buf, nil); err != nil || !bytes.Equal(buf[:200], pt[:200]) {
+		t.Error("in place Open failed")
+	}
+	for i := 0; i < len(expected); i += 5 {
+		mod := append([]byte(nil), expected...)
+		mod[i] ^= 0x04
+		if opened, err := a.Open(nil, nil, mod, nil); err == nil || opened != nil {
+			t.Errorf("Open accepted modified byte %d", i)
+		}
+	}
+}
+
+func TestCounterWrap(t *testing.T) {
+	key, pt := testData()
+	for _, size := range []int{256, 512, 1024} {
+		a, _ := New(key, size)
+		s := a.(*siv)
+		bs := size / 8
+
+		// The key stream continues at block 0 after block 2^64 - 1
+		iv := bytes.Repeat([]byte{0xff}, IVSize)
+		iv[0] = 0xfe
+		expected := make([]byte, 3*bs+1)
+		block := make([]byte, bs)
+		for i, counter := range []uint64{1<<64 - 2, 1<<64 - 1, 0, 1} {
+			s.cipher.SetTweak([]uint64{counter, binary.LittleEndian.Uint64(iv[8:])})
+			s.cipher.Encrypt(block, make([]byte, bs))
+			subtle.XORBytes(expected[i*bs:], pt[i*bs:len(expected)], block)
+		}
+		text := append([]byte(nil), pt[:len(expected)]...)
+		s.xorKeyStream(text, iv)
+		if !bytes.Equal(text, expected) {
+			t.Errorf("SIV-%d: wrong key stream at the counter wrap", size)
+		}
+
+		// A forged tag with the maximum counter fails authentication
+		for _, n := range []int{1, bs, 2 * bs, 5*bs + 3} {
+			forged := append(append([]byte(nil), pt[:n]...), bytes.Repeat([]byte{0xff}, IVSize)...)
+			if _, err := a.Open(nil, nil, forged, nil); err == nil {
+				t.Errorf("SIV-%d %d: Open accepted a forged tag", size, n)
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(make([]byte, MinKeySize-1), 512); err == nil {
+		t.Error("New accepted short key")
+	}
+	if _, err := New(make([]byte, 32), 384); err == nil {
+		t.Error("New accepted invalid state size")
+	}
+	a, _ := New(make([]byte, 32), 512)
+	defer func() {
+		if recover() == nil {
+			t.Error("Seal accepted a nonce")
+		}
+	}()
+	a.Seal(nil, make([]byte, 16), nil, nil)
+}
@@ -24,11 +24,15 @@
 // The implementation in this package supports:
 //    - All three state sizes of Skein and Threefish: 256, 512, and 1024 bits
 //    - Skein MAC
+//    - Optional arguments personalization, public key, key identifier, nonce, and custom schema
 //    - Variable length of hash and MAC input and output - even in numbers of bits
+//    - Extendable output (XOF) with an unbounded output stream
+//    - The Skein stream cipher
+//    - Skein key derivation (KDF) with the KeyIdentifier block
+//    - The Skein pseudo random number generator (PRNG)
 //    - Full message length as defined in the Skein paper (2^96 -1 bytes, not just a meager 4 GiB :-) )
-//    - Tested with the official test vectors that are part of the NIST CD (except Tree hashes)
-//
-// The implementation does not support tree hashing.
+//    - Skein tree hashing, also parallel hashing of large inputs with several goroutines
+//    - Tested with the official test vectors that are part of the NIST CD
 package skein
 
 import (
@@ -67,6 +71,7 @@ type Skein struct {
     config        *skeinConfiguration
     cipher        *threefish.Cipher
     ubiParameters *ubiTweak
+    tree          *skeinTree
     inputBuffer   []byte
     cipherInput   []uint64
     state         []uint64
@@ -86,11 +91,125 @@ func (s outputSizeError) Error() string {
 
 // Convenience functions to make it easier to create new hashes
 
+// Create a new Skein hash instance - 224bit (28 byte) hash size.
+// This Skein hash uses the 512bit state length
+//
+func New224() hash.Hash {
+    return newStandard(Skein512, 224)
+}
+
 // Create a new Skein hash instance - 256bit (32 byte) hash size.
 // This Skein hash uses the 512bit state length
 //
 func New256() hash.Hash {
-    h, _ := New(Skein512, 256) // Ignore error - we use correct sizes here
+    return newStandard(Skein512, 256)
+}
+
+// Create a new Skein hash instance - 384bit (48 byte) hash size.
+// This Skein hash uses the 512bit state length
+//
+func New384() hash.Hash {
+    return newStandard(Skein512, 384)
+}
+
+// Create a new Skein hash instance - 512bit (64 byte) hash size.
+// This Skein hash uses the 512bit state length
+//
+func New512() hash.Hash {
+    return newStandard(Skein512, 512)
+}
+
+// Create a new Skein hash instance - 1024bit (128 byte) hash size.
+// This Skein hash uses the 1024bit state length
+//
+func New1024() hash.Hash {
+    return newStandard(Skein1024, 1024)
+}
+
+// The following functions create the standard Skein variants for
+// an explicit state size. The name contains the state size and the
+// hash size in bits, for example New256_128 creates Skein-256-128.
+
+// Create a new Skein-256-128 hash instance - 128bit (16 byte) hash size.
+//
+func New256_128() hash.Hash {
+    return newStandard(Skein256, 128)
+}
+
+// Create a new Skein-256-160 hash instance - 160bit (20 byte) hash size.
+//
+func New256_160() hash.Hash {
+    return newStandard(Skein256, 160)
+}
+
+// Create a new Skein-256-224 hash instance - 224bit (28 byte) hash size.
+//
+func New256_224() hash.Hash {
+    return newStandard(Skein256, 224)
+}
+
+// Create a new Skein-256-256 hash instance - 256bit (32 byte) hash size.
+//
+func New256_256() hash.Hash {
+    return newStandard(Skein256, 256)
+}
+
+// Create a new Skein-512-128 hash instance - 128bit (16 byte) hash size.
+//
+func New512_128() hash.Hash {
+    return newStandard(Skein512, 128)
+}
+
+// Create a new Skein-512-160 hash instance - 160bit (20 byte) hash size.
+//
+func New512_160() hash.Hash {
+    return newStandard(Skein512, 160)
+}
+
+// Create a new Skein-512-224 hash instance - 224bit (28 byte) hash size.
+//
+func New512_224() hash.Hash {
+    return newStandard(Skein512, 224)
+}
+
+// Create a new Skein-512-256 hash instance - 256bit (32 byte) hash size.
+//
+func New512_256() hash.Hash {
+    return newStandard(Skein512, 256)
+}
+
+// Create a new Skein-512-384 hash instance - 384bit (48 byte) hash size.
+//
+func New512_384() hash.Hash {
+    return newStandard(Skein512, 384)
+}
+
+// Create a new Skein-512-512 hash instance - 512bit (64 byte) hash size.
+//
+func New512_512() hash.Hash {
+    return newStandard(Skein512, 512)
+}
+
+// Create a new Skein-1024-384 hash instance - 384bit (48 byte) hash size.
+//
+func New1024_384() hash.Hash {
+    return newStandard(Skein1024, 384)
+}
+
+// Create a new Skein-1024-512 hash instance - 512bit (64 byte) hash size.
+//
+func New1024_512() hash.Hash {
+    return newStandard(Skein1024, 512)
+}
+
+// Create a new Skein-1024-1024 hash instance - 1024bit (128 byte) hash size.
+//
+func New1024_1024() hash.Hash {
+    return newStandard(Skein1024, 1024)
+}
+
+func newStandard(stateSize, outputSize int) hash.Hash {
+    h, _ := New(stateSize, outputSize) // Ignore error - we use correct sizes here
     return h
 }
 
@@ -124,20 +243,25 @@ func (s *Skein) Write(p []byte) (nn int, err error) {
     return
 }
 
-// Sum returns the current hash, without changing the
-// underlying hash state.
-// TODO: discuss if this makes sense for Skein - Skein works with 64bit int internally
+// Sum appends the current hash to b and returns the resulting slice.
+// It does not change the underlying hash state.
+//
+// Sum computes the hash on a copy of the Skein context, thus an
+// application may continue to add data after it called Sum.
+//
 func (s *Skein) Sum(b []byte) []byte {
-    return s.finalIntern()
+    return append(b, s.copy().finalIntern()...)
 }
 
 // BlockSize returns the hash's underlying block size.
 // The Write method must be able to accept any amount
 // of data, but it may operate more efficiently if all writes
 // are a multiple of the block size.
+//
+// The block size of Skein is the Threefish state size in bytes.
 func (s *Skein) BlockSize() int {
-    return s.getHashSize() / 8
-} 
+    return s.cipherStateWords * 8
+}
 
 // Initializes the Skein hash instance.
 //
@@ -174,35 +298,127 @@ func New(stateSize, outputSize int) (*Skein, error) {
 //     The output size of the hash in bits. Output size must greater 
 //     than zero.
 // treeInfo
-//     Not yet supported.
+//     The tree parameters leaf size, fan-out, and maximum tree height, see
+//     TreeInfo. If treeInfo is zero Skein uses sequential hashing.
 // key
 //     The key for a message authenication code (MAC)
 //
 func NewExtended(stateSize, outputSize, treeInfo int, key []byte) (*Skein, error) {
-    if stateSize != 256 && stateSize != 512 && stateSize != 1024 {
-        return nil, stateSizeError(stateSize)
+    return NewWithParams(&Params{StateSize: stateSize, OutputSize: outputSize,
+        TreeInfo: treeInfo, Key: key})
+}
+
+// Params holds the parameters of a Skein hash instance including all
+// optional arguments defined in the Skein V1.3 specification.
+//
+// Skein processes the optional arguments in the order Key, Configuration,
+// Personalization, PublicKey, KeyIdentifier, and Nonce, each in its own UBI
+// block with the matching block type. Empty optional arguments are not
+// processed.
+//
+type Params struct {
+    StateSize  int    // The state size in bits: 256, 512, or 1024
+    OutputSize int    // The output size in bits, must be greater than zero
+    TreeInfo   int    // The tree parameters, see TreeInfo, zero for sequential hashing
+    Schema     []byte // The 4 byte schema identifier, "SHA3" if empty
+
+    Key             []byte // The key for a message authentication code (MAC)
+    Personalization []byte // Personalization string, e.g. application and date
+    PublicKey       []byte // Public key to bind a hash to a signing key
+    KeyIdentifier   []byte // Key identifier for key derivation
+    Nonce           []byte // Nonce for stream cipher and randomized hashing
+}
+
+type schemaError int
+
+func (s schemaError) Error() string {
+    return "crypto/skein: invalid Skein schema length " + strconv.Itoa(int(s))
+}
+
+// Initializes the Skein hash instance with all parameters.
+//
+// p
+//     The parameters of the hash, see Params.
+//
+func NewWithParams(p *Params) (*Skein, error) {
+    if p.OutputSize <= 0 {
+        return nil, outputSizeError(p.OutputSize)
     }
-    if outputSize <= 0 {
-        return nil, outputSizeError(outputSize)
+    return newSkein(p, uint64(p.OutputSize))
+}
+
+// Create a Skein instance, the configuration block uses configOutput
+// as output length.
+//
+func newSkein(p *Params, configOutput uint64) (*Skein, error) {
+    if p.StateSize != 256 && p.StateSize != 512 && p.StateSize != 1024 {
+        return nil, stateSizeError(p.StateSize)
+    }
+    sch := p.Schema
+    if len(sch) == 0 {
+        sch = schema[:] // "SHA3"
+    }
+    if len(sch) != len(schema) {
+        return nil, schemaError(len(sch))
     }
     s := new(Skein)
-    s.setup(stateSize, outputSize)
+    s.setup(p.StateSize, p.OutputSize)
     // compute the initial chaining state values, based on key
-    if len(key) > 0 { // do we have a key?
-        s.outputBytes = s.cipherStateWords * 8
-        s.ubiParameters.startNewBlockType(uint64(Key))
-        s.Update(key) // hash the key
-        s.finalPad()  // computes new Skein state
+    if len(p.Key) > 0 { // do we have a key?
+        s.processUbi(Key, p.Key)
     }
-    s.outputBytes = (outputSize + 7) / 8 // re-compute here
     s.config = newSkeinConfiguration(s)
-    s.config.setSchema(schema[:]) // "SHA3"
+    s.config.setSchema(sch)
     s.config.setVersion(1)
+    s.config.setOutputLength(configOutput)
 
+    if p.TreeInfo != 0 {
+        var err error
+        if s.tree, err = newSkeinTree(s, p.TreeInfo); err != nil {
+            return nil, err
+        }
+        s.config.setTreeLeafSize(byte(p.TreeInfo))
+        s.config.setTreeFanOutSize(byte(p.TreeInfo >> 8))
+        s.config.setMaxTreeHeight(byte(p.TreeInfo >> 16))
+    }
     s.initializeConf(chainedConfig)
+
+    // Process the optional arguments that follow the configuration block
+    optional := []struct {
+        blockType int
+        data      []byte
+    }{
+        {Personalization, p.Personalization},
+        {PublicKey, p.PublicKey},
+        {KeyIdentifier, p.KeyIdentifier},
+        {Nonce, p.Nonce},
+    }
+    processed := false
+    for _, o := range optional {
+        if len(o.data) > 0 {
+            s.processUbi(o.blockType, o.data)
+            processed = true
+        }
+    }
+    if processed {
+        // The message processing starts with the chained state, use it
+        // as the configuration value to keep it for Reset
+        copy(s.config.configValue, s.state)
+        s.initialize()
+    }
     return s, nil
 }
 
+// Process a complete UBI block of the given type and compute a new
+// chaining state.
+//
+func (s *Skein) processUbi(blockType int, data []byte) {
+    s.ubiParameters.startNewBlockType(uint64(blockType))
+    s.bytesFilled = 0
+    s.update(data)
+    s.finalPad()
+}
+
 // Initialize the internal variables
 //
 func (s *Skein) setup(stateSize, outputSize int) {
@@ -224,6 +440,26 @@ func (s *Skein) setup(stateSize, outputSize int) {
     s.ubiParameters = newUbiTweak()
 }
 
+// Return a deep copy of the Skein context.
+//
+// The copy shares the immutable configuration but has its own cipher,
+// tweak, buffers, and tree state.
+//
+func (s *Skein) copy() *Skein {
+    c := new(Skein)
+    *c = *s
+    c.cipher, _ = threefish.NewSize(s.cipherStateWords * 64)
+    c.ubiParameters = newUbiTweak()
+    c.ubiParameters.setTweak(s.ubiParameters.getTweak())
+    c.inputBuffer = append([]byte(nil), s.inputBuffer...)
+    c.cipherInput = make([]uint64, s.cipherStateWords)
+    c.state = append([]uint64(nil), s.state...)
+    if s.tree != nil {
+        c.tree = s.tree.copy(c)
+    }
+    return c
+}
+
 // Initialize with state variables provided by application.
 // 
 // Applications may use this method if they provide their own Skein
@@ -246,6 +482,9 @@ func (s *Skein) initializeWithState(externalState []uint64) {
     s.ubiParameters.startNewBlockType(uint64(Message))
     // Reset bytes filled
     s.bytesFilled = 0
+    if s.tree != nil {
+        s.tree.reset()
+    }
 }
 
 // Standard internal initialize function.
@@ -258,6 +497,9 @@ func (s *Skein) initialize() {
     // Set up tweak for message block
     s.ubiParameters.startNewBlockType(uint64(Message))
     s.bytesFilled = 0
+    if s.tree != nil {
+        s.tree.reset()
+    }
 }
 
 // Internal initialization function that sets up the state variables
@@ -320,7 +562,12 @@ func (s lengthError) Error() string {
 //
 func (s *Skein) UpdateBits(input []byte, numBits int) error {
 
-    if s.ubiParameters.isBitPad() {
+    // In tree mode the last bits belong to the current leaf
+    u := s
+    if s.tree != nil {
+        u = s.tree.leaf()
+    }
+    if u.ubiParameters.isBitPad() {
         return statusError(0)
     }
     if (numBits+7)/8 != len(input) {
@@ -334,8 +581,8 @@ func (s *Skein) UpdateBits(input []byte, numBits int) error {
     }
     // Mask partial byte and set BitPad flag before doFinal()
     mask := byte(1 << (7 - uint(numBits&7))) // partial byte bit mask
-    s.inputBuffer[s.bytesFilled-1] = byte((s.inputBuffer[s.bytesFilled-1] & (0 - mask)) | mask)
-    s.ubiParameters.setBitPad(true)
+    u.inputBuffer[u.bytesFilled-1] = byte((u.inputBuffer[u.bytesFilled-1] & (0 - mask)) | mask)
+    u.ubiParameters.setBitPad(true)
     return nil
 }
 
@@ -346,8 +593,18 @@ func (s *Skein) UpdateBits(input []byte, numBits int) error {
 //
 func (s *Skein) Update(input []byte) {
 
+    if s.tree != nil {
+        s.tree.update(0, input)
+        return
+    }
+    s.update(input)
+}
+
+// Add data to the current UBI block, sequential processing.
+//
+func (s *Skein) update(input []byte) {
     // Fill input buffer
-    for i := 0; i < len(input); i++ {
+    for len(input) > 0 {
         // Do a transform if the input buffer is filled
         if s.bytesFilled == s.cipherStateWords*8 {
             // Copy input buffer to cipher input buffer
@@ -364,8 +621,9 @@ func (s *Skein) Update(input []byte) {
             // Reset buffer fill count
             s.bytesFilled = 0
         }
-        s.inputBuffer[s.bytesFilled] = input[i]
-        s.bytesFilled++
+        n := copy(s.inputBuffer[s.bytesFilled:], input)
+        s.bytesFilled += n
+        input = input[n:]
     }
 }
 
@@ -381,20 +639,7 @@ func (s *Skein) DoFinal() (hash []byte) {
 }
 
 func (s *Skein) finalIntern() (hash []byte) {
-    // Pad leftover space in input buffer with zeros
-    // and copy to cipher input buffer
-    for i := s.bytesFilled; i < len(s.inputBuffer); i++ {
-        s.inputBuffer[i] = 0
-    }
-    for i := 0; i < s.cipherStateWords; i++ {
-        s.cipherInput[i] = binary.LittleEndian.Uint64(s.inputBuffer[i*8 : i*8+8])
-    }
-    // Do final message block
-    s.ubiParameters.setFinalBlock(true)
-    s.processBlock(s.bytesFilled)
-
-    // Clear cipher input
-    copy(s.cipherInput, nullStateWords[:])
+    s.finalMessage()
 
     hash = make([]byte, s.outputBytes)
     oldState := make([]uint64, s.cipherStateWords)
@@ -403,30 +648,62 @@ func (s *Skein) finalIntern() (hash []byte) {
     copy(oldState, s.state)
 
     stateBytes := s.cipherStateWords * 8
-    for i := 0; i < s.outputBytes; i += s.cipherStateWords * 8 {
-        s.ubiParameters.startNewBlockType(uint64(Out))
-        s.ubiParameters.setFinalBlock(true)
-        s.processBlock(8)
-
+    var counter uint64
+    for i := 0; i < s.outputBytes; i += stateBytes {
         // Output a chunk of the hash
         outputSize := s.outputBytes - i
         if outputSize > stateBytes {
             outputSize = stateBytes
         }
-
-        // The new state create by processBlock() is (part of) the hash
-        s.putBytes(s.state, hash[i:i+outputSize])
-
-        // Restore current state of hash to compute next hash output
-        copy(s.state, oldState)
+        s.outputBlock(oldState, counter, hash[i:i+outputSize])
 
         // Increment counter, Skein performs a Counter Mode threefish to compute hash output
-        s.cipherInput[0]++
+        counter++
     }
-    // at this point the internal state (s.state) is unchanged
+    // Restore current state of hash
+    copy(s.state, oldState)
     return
 }
 
+// Complete the message processing. After finalMessage the state
+// contains the chaining value that is the input of the output stage.
+//
+func (s *Skein) finalMessage() {
+    if s.tree != nil {
+        // The root chaining value of the tree is the input to the output stage
+        s.tree.final(s.state)
+    } else {
+        // Do final message block
+        s.finalPad()
+    }
+}
+
+// Compute one block of the Skein output stage.
+//
+// The output stage runs Threefish in counter mode, thus applications may
+// compute any output block independently. outputBlock overwrites the
+// state.
+//
+// chain
+//     The chaining value after the message processing.
+// counter
+//     The number of the output block.
+// output
+//     Receives the output block, may be shorter than the state size.
+//
+func (s *Skein) outputBlock(chain []uint64, counter uint64, output []byte) {
+    copy(s.state, chain)
+    copy(s.cipherInput, nullStateWords[:])
+    s.cipherInput[0] = counter
+
+    s.ubiParameters.startNewBlockType(uint64(Out))
+    s.ubiParameters.setFinalBlock(true)
+    s.processBlock(8)
+
+    // The new state create by processBlock() is the output block
+    s.putBytes(s.state, output)
+}
+
 // Return the Skein output hash size as number of bits
 func (s *Skein) getHashSize() int {
     return s.hashSize
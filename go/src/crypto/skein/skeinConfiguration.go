@@ -92,6 +92,10 @@ func (c *skeinConfiguration) setVersion(version int) {
     c.configString[0] |= uint64(version) << 32
 }
 
+func (c *skeinConfiguration) setOutputLength(length uint64) {
+    c.configString[1] = length
+}
+
 func (c *skeinConfiguration) setTreeLeafSize(size byte) {
     c.configString[2] &^= uint64(0xff)
     c.configString[2] |= uint64(size)
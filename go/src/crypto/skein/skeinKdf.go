@@ -0,0 +1,102 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package skein
+
+import (
+    "io"
+)
+
+// A KDF is the Skein key derivation function as defined in the Skein
+// V1.3 specification.
+//
+// Skein processes the master key in the Key block and the derivation
+// context in the KeyIdentifier block. The output stage produces the
+// derived key material. A KDF implements io.Reader and returns io.EOF
+// after it produced the requested number of bytes.
+//
+type KDF struct {
+    xof       *XOF
+    remaining int64
+}
+
+type kdfLengthError int64
+
+func (k kdfLengthError) Error() string {
+    return "crypto/skein: invalid key derivation length"
+}
+
+// Initializes a Skein key derivation reader.
+//
+// The output length is part of the Skein configuration, thus the first n
+// bytes of two readers with different lengths differ.
+//
+// stateSize
+//     The Skein state size in bits. Supported values are 256, 512,
+//     and 1024
+// master
+//     The master key
+// keyID
+//     The key identifier, the context of the derived key material
+// length
+//     The number of bytes to derive
+//
+func NewKDF(stateSize int, master, keyID []byte, length int64) (*KDF, error) {
+    if length <= 0 || length >= 1<<61 {
+        return nil, kdfLengthError(length)
+    }
+    xof, err := newXOF(&Params{StateSize: stateSize, Key: master, KeyIdentifier: keyID},
+        uint64(length)*8)
+    if err != nil {
+        return nil, err
+    }
+    return &KDF{xof, length}, nil
+}
+
+// Read reads derived key material. It returns io.EOF after it produced
+// the requested number of bytes.
+//
+func (k *KDF) Read(p []byte) (int, error) {
+    if k.remaining == 0 {
+        return 0, io.EOF
+    }
+    if int64(len(p)) > k.remaining {
+        p = p[:k.remaining]
+    }
+    n, _ := k.xof.Read(p)
+    k.remaining -= int64(n)
+    return n, nil
+}
+
+// DeriveKey derives a key from a master key with Skein-512.
+//
+// master
+//     The master key
+// keyID
+//     The key identifier, the context of the derived key
+// length
+//     The length of the derived key in bytes
+//
+func DeriveKey(master, keyID []byte, length int) ([]byte, error) {
+    k, err := NewKDF(Skein512, master, keyID, int64(length))
+    if err != nil {
+        return nil, err
+    }
+    key := make([]byte, length)
+    k.Read(key)
+    return key, nil
+}
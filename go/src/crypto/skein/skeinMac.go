@@ -17,6 +17,13 @@
 //
 package skein
 
+import (
+    "crypto/subtle"
+)
+
+// The minimum length of a truncated MAC tag in bytes, see Equal.
+const MinTagSize = 4
+
 type SkeinMac struct {
     skein     *Skein
     stateSave []uint64
@@ -85,6 +92,63 @@ func (s *SkeinMac) DoFinal() (hash []byte) {
     return
 }
 
+// The following section implement the hash.Hash interface methods
+
+// Write adds more data to the current MAC.
+// It never returns an error.
+//
+func (s *SkeinMac) Write(p []byte) (nn int, err error) {
+    return s.skein.Write(p)
+}
+
+// Sum appends the current MAC to b and returns the resulting slice.
+// It does not change the underlying MAC state.
+//
+func (s *SkeinMac) Sum(b []byte) []byte {
+    return s.skein.Sum(b)
+}
+
+// Size returns the MAC size in bytes, see Skein.Size.
+//
+func (s *SkeinMac) Size() int {
+    return s.skein.Size()
+}
+
+// BlockSize returns the underlying block size, which is the Threefish
+// state size in bytes.
+//
+func (s *SkeinMac) BlockSize() int {
+    return s.skein.BlockSize()
+}
+
+// Verify reports whether tag is the MAC of the data written so far.
+//
+// The tag may be a truncated MAC, see Equal. Verify does not change the
+// MAC state. The comparison takes constant time.
+//
+func (s *SkeinMac) Verify(tag []byte) bool {
+    return Equal(s.Sum(nil), tag)
+}
+
+// Equal compares a computed MAC with a received tag in constant time.
+//
+// The tag may be the complete MAC or a truncated MAC that contains the
+// leading bytes of the MAC. Truncated tags must contain at least
+// MinTagSize bytes. The time Equal takes depends only on the length
+// of the tag, not on its content.
+//
+// mac
+//     The computed MAC.
+// tag
+//     The tag to check.
+//
+func Equal(mac, tag []byte) bool {
+    if len(tag) > len(mac) || (len(tag) < len(mac) && len(tag) < MinTagSize) || len(tag) == 0 {
+        return false
+    }
+    return subtle.ConstantTimeCompare(mac[:len(tag)], tag) == 1
+}
+
 // Resets a Skein context for further use.
 // 
 // Restores the saved chaining variables to reset the Skein context. 
@@ -94,3 +158,37 @@ func (s *SkeinMac) DoFinal() (hash []byte) {
 func (s *SkeinMac) Reset() {
     s.skein.initializeWithState(s.stateSave)
 }
+
+// Clone returns an independent copy of the Skein MAC context.
+//
+func (s *SkeinMac) Clone() *SkeinMac {
+    c := new(SkeinMac)
+    c.skein = s.skein.copy()
+    c.stateSave = append([]uint64(nil), s.stateSave...)
+    return c
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+//
+// The data contains the key dependent state of the MAC, applications
+// must protect it like the key.
+//
+func (s *SkeinMac) MarshalBinary() ([]byte, error) {
+    return s.skein.appendBinary([]byte(magicSkeinMac)), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+//
+// UnmarshalBinary restores a Skein MAC context saved by MarshalBinary.
+// The Skein MAC instance may be a zero value.
+//
+func (s *SkeinMac) UnmarshalBinary(data []byte) error {
+    sk := new(Skein)
+    if err := sk.unmarshal(data, magicSkeinMac); err != nil {
+        return err
+    }
+    s.skein = sk
+    // The key dependent state is the start state of each MAC
+    s.stateSave = append([]uint64(nil), sk.config.configValue...)
+    return nil
+}
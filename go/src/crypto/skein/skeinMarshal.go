@@ -0,0 +1,236 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package skein
+
+import (
+    "encoding/binary"
+)
+
+// The binary format of a Skein context. All integers are little endian.
+//
+//    magic           5 bytes, "skein" or "skmac"
+//    version         1 byte, marshalVersion
+//    state size      uint16, in bits
+//    hash size       uint64, in bits
+//    tree info       uint32, zero for sequential hashing
+//    config value    state size / 64 uint64 words
+//    UBI context     see below
+//
+// Tree hashing appends the number of tree levels as uint16 and for each
+// level the bytes hashed in the current node and on the level as uint64,
+// followed by the UBI context of the level's node.
+//
+// A UBI context contains the chaining state words, the two tweak words,
+// the number of buffered bytes as uint16 and the buffered bytes.
+//
+const (
+    magicSkein     = "skein"
+    magicSkeinMac  = "skmac"
+    marshalVersion = 1
+)
+
+type marshalError string
+
+func (m marshalError) Error() string {
+    return "crypto/skein: " + string(m)
+}
+
+// Clone returns an independent copy of the Skein context.
+//
+// Applications may use Clone to compute the hashes of several messages
+// that share a common prefix.
+//
+func (s *Skein) Clone() *Skein {
+    return s.copy()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+//
+// The data contains the complete Skein context, the configuration
+// and, in case of a keyed hash, the key dependent state. Applications
+// must protect the data like the key.
+//
+func (s *Skein) MarshalBinary() ([]byte, error) {
+    return s.appendBinary([]byte(magicSkein)), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+//
+// UnmarshalBinary restores a Skein context saved by MarshalBinary. The
+// Skein instance may be a zero value, UnmarshalBinary sets up the state
+// and output sizes as stored in the data.
+//
+func (s *Skein) UnmarshalBinary(data []byte) error {
+    return s.unmarshal(data, magicSkein)
+}
+
+func (s *Skein) appendBinary(b []byte) []byte {
+    b = append(b, marshalVersion)
+    b = binary.LittleEndian.AppendUint16(b, uint16(s.cipherStateWords*64))
+    b = binary.LittleEndian.AppendUint64(b, uint64(s.hashSize))
+    treeInfo := 0
+    if s.tree != nil {
+        treeInfo = s.tree.info
+    }
+    b = binary.LittleEndian.AppendUint32(b, uint32(treeInfo))
+    b = appendWords(b, s.config.configValue)
+    b = s.appendUbi(b)
+    if s.tree != nil {
+        b = binary.LittleEndian.AppendUint16(b, uint16(len(s.tree.levels)))
+        for _, l := range s.tree.levels {
+            b = binary.LittleEndian.AppendUint64(b, l.nodeFill)
+            b = binary.LittleEndian.AppendUint64(b, l.processed)
+            b = l.node.appendUbi(b)
+        }
+    }
+    return b
+}
+
+func (s *Skein) appendUbi(b []byte) []byte {
+    b = appendWords(b, s.state)
+    b = appendWords(b, s.ubiParameters.getTweak())
+    b = binary.LittleEndian.AppendUint16(b, uint16(s.bytesFilled))
+    return append(b, s.inputBuffer[:s.bytesFilled]...)
+}
+
+func appendWords(b []byte, words []uint64) []byte {
+    for _, w := range words {
+        b = binary.LittleEndian.AppendUint64(b, w)
+    }
+    return b
+}
+
+func (s *Skein) unmarshal(data []byte, magic string) error {
+    if len(data) < len(magic)+1 || string(data[:len(magic)]) != magic {
+        return marshalError("invalid Skein state identifier")
+    }
+    if data[len(magic)] != marshalVersion {
+        return marshalError("unsupported Skein state version")
+    }
+    d := &decoder{data: data[len(magic)+1:]}
+    stateSize := int(d.uint16())
+    hashSize := d.uint64()
+    treeInfo := int(d.uint32())
+    if d.err != nil {
+        return d.err
+    }
+    if stateSize != 256 && stateSize != 512 && stateSize != 1024 {
+        return stateSizeError(stateSize)
+    }
+    if hashSize == 0 || hashSize > 1<<31 {
+        return marshalError("invalid Skein output size")
+    }
+    c := new(Skein)
+    c.setup(stateSize, int(hashSize))
+    c.config = newSkeinConfiguration(c)
+    d.words(c.config.configValue)
+    if treeInfo != 0 {
+        var err error
+        if c.tree, err = newSkeinTree(c, treeInfo); err != nil {
+            return err
+        }
+    }
+    d.ubi(c)
+    if c.tree != nil {
+        levels := int(d.uint16())
+        if d.err == nil && (levels < 1 || levels > c.tree.maxHeight) {
+            return marshalError("invalid number of Skein tree levels")
+        }
+        for i := 0; i < levels && d.err == nil; i++ {
+            l := c.tree.level(i)
+            l.nodeFill = d.uint64()
+            l.processed = d.uint64()
+            d.ubi(l.node)
+            if l.nodeBytes > 0 && l.nodeFill > l.nodeBytes {
+                return marshalError("invalid Skein tree node")
+            }
+        }
+    }
+    if d.err != nil {
+        return d.err
+    }
+    if len(d.data) != 0 {
+        return marshalError("invalid Skein state length")
+    }
+    *s = *c
+    if s.tree != nil {
+        s.tree.parent = s
+    }
+    return nil
+}
+
+// A decoder reads the binary format. After the first error all
+// functions return zero values and the decoder keeps the error.
+//
+type decoder struct {
+    data []byte
+    err  error
+}
+
+func (d *decoder) next(n int) []byte {
+    if d.err != nil {
+        return nil
+    }
+    if len(d.data) < n {
+        d.err = marshalError("invalid Skein state length")
+        return nil
+    }
+    b := d.data[:n]
+    d.data = d.data[n:]
+    return b
+}
+
+func (d *decoder) uint16() uint16 {
+    if b := d.next(2); b != nil {
+        return binary.LittleEndian.Uint16(b)
+    }
+    return 0
+}
+
+func (d *decoder) uint32() uint32 {
+    if b := d.next(4); b != nil {
+        return binary.LittleEndian.Uint32(b)
+    }
+    return 0
+}
+
+func (d *decoder) uint64() uint64 {
+    if b := d.next(8); b != nil {
+        return binary.LittleEndian.Uint64(b)
+    }
+    return 0
+}
+
+func (d *decoder) words(words []uint64) {
+    for i := range words {
+        words[i] = d.uint64()
+    }
+}
+
+func (d *decoder) ubi(s *Skein) {
+    d.words(s.state)
+    var tweak [2]uint64
+    d.words(tweak[:])
+    s.ubiParameters.setTweak(tweak[:])
+    s.bytesFilled = int(d.uint16())
+    if d.err == nil && s.bytesFilled > len(s.inputBuffer) {
+        d.err = marshalError("invalid Skein buffer length")
+        return
+    }
+    copy(s.inputBuffer, d.next(s.bytesFilled))
+}
@@ -0,0 +1,202 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package skein
+
+import (
+    "io"
+    "runtime"
+    "strconv"
+    "sync"
+)
+
+// Number of bytes a worker reads with one ReadAt call. Workers hash
+// as many complete leaves as fit into this buffer in one job.
+var parallelBufferSize = 1 << 20
+
+// Number of jobs per worker that are processed before the chaining
+// values are fed into the tree.
+const parallelJobsPerWorker = 4
+
+type sizeError int64
+
+func (s sizeError) Error() string {
+    return "crypto/skein: invalid data size " + strconv.FormatInt(int64(s), 10)
+}
+
+type parallelJob struct {
+    first, count uint64 // the leaves to hash
+    cv           []byte // receives the chaining values of the leaves
+    err          error
+    done         *sync.WaitGroup
+}
+
+// Hash data read from an io.ReaderAt using several goroutines.
+//
+// HashReaderAt resets the Skein instance, hashes the first size bytes of r
+// as a complete message and returns the hash. The Skein instance is reset
+// again before HashReaderAt returns. An *os.File is an io.ReaderAt, thus
+// applications can hash large files this way.
+//
+// If the Skein instance uses tree hashing the workers hash the leaves
+// independently, the result is the same as if the data was hashed with
+// Update. Sequential Skein cannot be parallelized, in this case
+// HashReaderAt reads and hashes the data with one goroutine.
+//
+// r
+//     The data source.
+// size
+//     Number of bytes to read from r.
+// workers
+//     Number of goroutines that hash leaves. If workers is zero or
+//     negative HashReaderAt uses runtime.NumCPU goroutines.
+//
+func (s *Skein) HashReaderAt(r io.ReaderAt, size int64, workers int) ([]byte, error) {
+    s.Reset()
+    if size < 0 {
+        return nil, sizeError(size)
+    }
+    t := s.tree
+    if t == nil {
+        if _, err := io.Copy(s, io.NewSectionReader(r, 0, size)); err != nil {
+            s.Reset()
+            return nil, err
+        }
+        return s.DoFinal(), nil
+    }
+    if workers <= 0 {
+        workers = runtime.NumCPU()
+    }
+    blockBytes := uint64(s.cipherStateWords * 8)
+
+    // All leaves except the last one are complete and can be hashed in
+    // parallel. The last leaf goes through Update to keep it in progress.
+    var full uint64
+    if size > 0 {
+        full = uint64(size-1) / t.leafBytes
+    }
+    jobLeaves := uint64(parallelBufferSize) / t.leafBytes
+    if jobLeaves == 0 {
+        jobLeaves = 1
+    }
+    window := jobLeaves * uint64(workers*parallelJobsPerWorker)
+    if window > full {
+        window = full
+    }
+    jobs := make(chan *parallelJob)
+    defer close(jobs)
+    bufSize := jobLeaves * t.leafBytes
+    if bufSize > uint64(parallelBufferSize) {
+        bufSize = uint64(parallelBufferSize)
+    }
+    for i := 0; i < workers && full > 0; i++ {
+        go t.parallelWorker(r, make([]byte, bufSize), jobs)
+    }
+    cv := make([]byte, window*blockBytes)
+    var pending []*parallelJob
+    var done sync.WaitGroup
+
+    for first := uint64(0); first < full; first += window {
+        leaves := full - first
+        if leaves > window {
+            leaves = window
+        }
+        pending = pending[:0]
+        for i := uint64(0); i < leaves; i += jobLeaves {
+            count := leaves - i
+            if count > jobLeaves {
+                count = jobLeaves
+            }
+            job := &parallelJob{first: first + i, count: count, done: &done}
+            job.cv = cv[i*blockBytes : (i+count)*blockBytes]
+            pending = append(pending, job)
+        }
+        done.Add(len(pending))
+        for _, job := range pending {
+            jobs <- job
+        }
+        done.Wait()
+        for _, job := range pending {
+            if job.err != nil {
+                s.Reset()
+                return nil, job.err
+            }
+        }
+        t.update(1, cv[:leaves*blockBytes])
+    }
+    leaf := t.levels[0]
+    leaf.processed = full * t.leafBytes
+    t.startNode(leaf)
+    last := io.NewSectionReader(r, int64(leaf.processed), size-int64(leaf.processed))
+    if _, err := io.Copy(s, last); err != nil {
+        s.Reset()
+        return nil, err
+    }
+    return s.DoFinal(), nil
+}
+
+// A worker reads and hashes complete leaves until the jobs channel
+// is closed.
+//
+func (t *skeinTree) parallelWorker(r io.ReaderAt, buf []byte, jobs <-chan *parallelJob) {
+    node := t.newNode()
+    for job := range jobs {
+        job.err = t.hashLeaves(r, node, buf, job.first, job.count, job.cv)
+        job.done.Done()
+    }
+}
+
+// Hash count complete leaves starting with leaf first and store their
+// chaining values in cv.
+//
+func (t *skeinTree) hashLeaves(r io.ReaderAt, node *Skein, buf []byte, first, count uint64, cv []byte) error {
+    blockBytes := uint64(len(node.state) * 8)
+    pos := first * t.leafBytes
+    end := pos + count*t.leafBytes
+    var fill, idx uint64
+
+    t.startNodeAt(node, 1, pos)
+    for pos < end {
+        n := uint64(len(buf))
+        if n > end-pos {
+            n = end - pos
+        }
+        if k, err := r.ReadAt(buf[:n], int64(pos)); uint64(k) != n {
+            if err == nil || err == io.EOF {
+                err = io.ErrUnexpectedEOF
+            }
+            return err
+        }
+        for data := buf[:n]; len(data) > 0; {
+            m := uint64(len(data))
+            if m > t.leafBytes-fill {
+                m = t.leafBytes - fill
+            }
+            node.Update(data[:m])
+            data = data[m:]
+            if fill += m; fill == t.leafBytes {
+                node.finalPad()
+                node.putBytes(node.state, cv[idx*blockBytes:(idx+1)*blockBytes])
+                idx++
+                fill = 0
+                t.startNodeAt(node, 1, (first+idx)*t.leafBytes)
+            }
+        }
+        pos += n
+    }
+    return nil
+}
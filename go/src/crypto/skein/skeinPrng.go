@@ -0,0 +1,129 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package skein
+
+import (
+    "encoding/binary"
+    "sync"
+)
+
+// A PRNG is the Skein pseudo random number generator as described in
+// the Skein paper.
+//
+// The PRNG keeps a state of the Skein state size. Each request runs the
+// Skein output stage with the state as chaining value. The first output
+// block replaces the state, the following blocks are the random output.
+// Thus a PRNG rekeys after each request and an attacker who learns the
+// state cannot compute earlier output.
+//
+// A seeded PRNG produces a reproducible sequence. PRNG implements
+// io.Reader and the math/rand/v2 Source interface, applications may
+// share a PRNG between goroutines.
+//
+type PRNG struct {
+    mu     sync.Mutex
+    state  []uint64
+    out    *Skein // computes the output blocks
+    block  []byte
+    hash   *Skein // used to reseed
+    buffer []byte
+}
+
+// Initializes a Skein PRNG.
+//
+// stateSize
+//     The Skein state size in bits. Supported values are 256, 512,
+//     and 1024
+// seed
+//     The initial seed, applications should provide at least as many
+//     bytes of entropy as the state size
+//
+func NewPRNG(stateSize int, seed []byte) (*PRNG, error) {
+    h, err := New(stateSize, stateSize)
+    if err != nil {
+        return nil, err
+    }
+    p := new(PRNG)
+    p.hash = h
+    p.state = make([]uint64, stateSize/64)
+    p.out = new(Skein)
+    p.out.setup(stateSize, stateSize)
+    p.block = make([]byte, stateSize/8)
+    p.buffer = make([]byte, stateSize/8)
+    p.Reseed(seed)
+    return p, nil
+}
+
+// Reseed mixes new entropy into the PRNG state.
+//
+// The new state is the Skein hash of the old state and the entropy.
+//
+func (p *PRNG) Reseed(entropy []byte) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.out.putBytes(p.state, p.buffer)
+    p.hash.Update(p.buffer)
+    p.hash.Update(entropy)
+    h := p.hash.DoFinal()
+    for i := range p.state {
+        p.state[i] = binary.LittleEndian.Uint64(h[i*8:])
+    }
+}
+
+// Read fills b with random bytes. It never returns an error.
+//
+func (p *PRNG) Read(b []byte) (int, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.generate(b)
+    return len(b), nil
+}
+
+// Uint64 returns a random 64 bit value. Together with Read this
+// implements the math/rand/v2 Source interface.
+//
+func (p *PRNG) Uint64() uint64 {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    var b [8]byte
+    p.generate(b[:])
+    return binary.LittleEndian.Uint64(b[:])
+}
+
+// Produce one request of random output and replace the state.
+//
+func (p *PRNG) generate(b []byte) {
+    blockBytes := len(p.block)
+    var counter uint64 = 1
+    for i := 0; i < len(b); i += blockBytes {
+        p.out.outputBlock(p.state, counter, p.block)
+        copy(b[i:], p.block)
+        counter++
+    }
+    // The first output block is the new state
+    p.out.outputBlock(p.state, 0, p.block)
+    for i := range p.state {
+        p.state[i] = binary.LittleEndian.Uint64(p.block[i*8:])
+    }
+    for i := range p.block {
+        p.block[i] = 0
+    }
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package skein
+
+import (
+    "crypto/subtle"
+)
+
+// A StreamCipher is the Skein stream cipher as described in the Skein
+// paper.
+//
+// Skein processes the key in the Key block, the nonce in the Nonce block
+// and an empty message. The output stage then produces the key stream.
+// StreamCipher implements crypto/cipher.Stream.
+//
+// NOTE: never use the same key and nonce combination for two messages.
+//
+type StreamCipher struct {
+    xof *XOF
+    buf []byte
+}
+
+type streamKeyError int
+
+func (s streamKeyError) Error() string {
+    return "crypto/skein: stream cipher requires a key"
+}
+
+// Initializes a Skein stream cipher.
+//
+// key
+//     The key bytes, must not be empty
+// nonce
+//     The nonce, must be unique for each message encrypted with the key
+// stateSize
+//     The Skein state size in bits. Supported values are 256, 512,
+//     and 1024
+//
+func NewStreamCipher(key, nonce []byte, stateSize int) (*StreamCipher, error) {
+    if len(key) == 0 {
+        return nil, streamKeyError(0)
+    }
+    xof, err := NewXOFWithParams(&Params{StateSize: stateSize, Key: key, Nonce: nonce})
+    if err != nil {
+        return nil, err
+    }
+    return &StreamCipher{xof, make([]byte, 8*xof.BlockSize())}, nil
+}
+
+// XORKeyStream XORs each byte in the given slice with a byte from the
+// cipher's key stream. Dst and src may point at the same memory.
+//
+// Subsequent calls continue the key stream, thus an application may
+// encrypt a large message in several parts.
+//
+func (s *StreamCipher) XORKeyStream(dst, src []byte) {
+    if len(dst) < len(src) {
+        panic("crypto/skein: output smaller than input")
+    }
+    for len(src) > 0 {
+        n := len(src)
+        if n > len(s.buf) {
+            n = len(s.buf)
+        }
+        s.xof.Read(s.buf[:n])
+        subtle.XORBytes(dst[:n], src[:n], s.buf[:n])
+        dst = dst[n:]
+        src = src[n:]
+    }
+}
+
+// Seek sets the key stream position for the next XORKeyStream call,
+// see io.Seeker. Applications may use Seek to decrypt a part of a
+// message. Seek does not support io.SeekEnd.
+//
+func (s *StreamCipher) Seek(offset int64, whence int) (int64, error) {
+    return s.xof.Seek(offset, whence)
+}
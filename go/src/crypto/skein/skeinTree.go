@@ -0,0 +1,244 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package skein
+
+import (
+    "strconv"
+)
+
+// The tree parameters use one byte each in the configuration string.
+// TreeInfo packs them in the same layout as the third configuration
+// word, thus the result can be used as treeInfo argument of NewExtended.
+//
+// leaf
+//     The leaf size exponent Y_l, a leaf contains 2^Y_l state blocks
+// fanOut
+//     The fan-out exponent Y_f, a node contains 2^Y_f chaining values
+// maxHeight
+//     The maximum tree height Y_m
+//
+func TreeInfo(leaf, fanOut, maxHeight int) int {
+    return (leaf & 0xff) | (fanOut&0xff)<<8 | (maxHeight&0xff)<<16
+}
+
+type treeInfoError int
+
+func (t treeInfoError) Error() string {
+    return "crypto/skein: invalid Skein tree info " + strconv.Itoa(int(t))
+}
+
+// The tree state of a Skein instance.
+//
+// Tree hashing processes the message level by level. Each level holds
+// exactly one node in progress, all completed nodes of a level are
+// reduced to their chaining value and fed into the next level. Nodes
+// are completed lazily, only if more data arrives. Thus the final node
+// of each level is still available when the hash is finalized.
+//
+type skeinTree struct {
+    leafBytes,
+    nodeBytes uint64
+    maxHeight int
+    info      int
+    parent    *Skein
+    levels    []*treeLevel
+}
+
+type treeLevel struct {
+    node      *Skein // the node in progress
+    level     int    // the tree level as used in the tweak
+    nodeBytes uint64 // maximum node size, zero if not limited
+    nodeFill  uint64 // bytes hashed in current node
+    processed uint64 // bytes hashed on this level
+    cv        []byte // chaining value of the last completed node
+}
+
+// Check the tree parameters and create a new tree for a Skein instance.
+//
+// The Skein configuration of the parent must be set up already, the tree
+// uses the configuration value as start state of all its nodes.
+//
+func newSkeinTree(parent *Skein, treeInfo int) (*skeinTree, error) {
+    leaf := treeInfo & 0xff
+    fanOut := (treeInfo >> 8) & 0xff
+    maxHeight := (treeInfo >> 16) & 0xff
+
+    if treeInfo>>24 != 0 || leaf < 1 || fanOut < 1 || maxHeight < 2 {
+        return nil, treeInfoError(treeInfo)
+    }
+    t := new(skeinTree)
+    t.parent = parent
+    t.leafBytes = nodeSize(parent.cipherStateWords*8, leaf)
+    t.nodeBytes = nodeSize(parent.cipherStateWords*8, fanOut)
+    t.maxHeight = maxHeight
+    t.info = treeInfo
+    t.levels = []*treeLevel{t.newLevel(1)}
+    return t, nil
+}
+
+// Compute the node size in bytes. Sizes that don't fit into 64 bits
+// are limited because no message can fill such a node.
+//
+func nodeSize(blockBytes, exponent int) uint64 {
+    if exponent > 56 {
+        return ^uint64(0)
+    }
+    return uint64(blockBytes) << uint(exponent)
+}
+
+func (t *skeinTree) newLevel(level int) *treeLevel {
+    l := new(treeLevel)
+    l.level = level
+    switch {
+    case level == t.maxHeight:
+        l.nodeBytes = 0 // the last allowed level hashes all remaining data
+    case level == 1:
+        l.nodeBytes = t.leafBytes
+    default:
+        l.nodeBytes = t.nodeBytes
+    }
+    l.node = t.newNode()
+    l.cv = make([]byte, t.parent.cipherStateWords*8)
+    t.startNode(l)
+    return l
+}
+
+// Return a deep copy of the tree that belongs to the Skein
+// instance parent.
+//
+func (t *skeinTree) copy(parent *Skein) *skeinTree {
+    c := new(skeinTree)
+    *c = *t
+    c.parent = parent
+    c.levels = make([]*treeLevel, len(t.levels))
+    for i, l := range t.levels {
+        cl := new(treeLevel)
+        *cl = *l
+        cl.node = l.node.copy()
+        cl.cv = append([]byte(nil), l.cv...)
+        c.levels[i] = cl
+    }
+    return c
+}
+
+// Return the tree level with the given index, create it if necessary.
+//
+func (t *skeinTree) level(idx int) *treeLevel {
+    if idx == len(t.levels) {
+        t.levels = append(t.levels, t.newLevel(idx+1))
+    }
+    return t.levels[idx]
+}
+
+// Reset the tree to process a new message.
+//
+func (t *skeinTree) reset() {
+    t.levels = t.levels[:1]
+    t.levels[0].processed = 0
+    t.startNode(t.levels[0])
+}
+
+// Return the Skein instance that hashes the current leaf.
+//
+func (t *skeinTree) leaf() *Skein {
+    return t.levels[0].node
+}
+
+// Start a new node on a tree level.
+//
+// A node starts with the chained configuration value. The tweak contains
+// the tree level and the offset of the node's data inside its level.
+//
+func (t *skeinTree) startNode(l *treeLevel) {
+    t.startNodeAt(l.node, l.level, l.processed)
+    l.nodeFill = 0
+}
+
+// Prepare a Skein instance to hash a node at the given tree level
+// and offset.
+//
+func (t *skeinTree) startNodeAt(node *Skein, level int, offset uint64) {
+    copy(node.state, t.parent.config.configValue)
+    node.ubiParameters.startNewBlockType(uint64(Message))
+    node.ubiParameters.setTreeLevel(level)
+    node.ubiParameters.setBitsProcessed(offset)
+    node.bytesFilled = 0
+}
+
+// Create a Skein instance that can hash tree nodes.
+//
+func (t *skeinTree) newNode() *Skein {
+    stateSize := t.parent.cipherStateWords * 64
+    node := new(Skein)
+    node.setup(stateSize, stateSize)
+    return node
+}
+
+// Complete the node in progress and return its chaining value.
+//
+func (t *skeinTree) finishNode(l *treeLevel) []byte {
+    l.node.finalPad()
+    l.node.putBytes(l.node.state, l.cv)
+    return l.cv
+}
+
+// Hash data on a tree level.
+//
+// Completes full nodes before data is added and propagates their
+// chaining values to the next level.
+//
+func (t *skeinTree) update(idx int, input []byte) {
+    l := t.level(idx)
+    for len(input) > 0 {
+        if l.nodeBytes > 0 && l.nodeFill == l.nodeBytes {
+            t.update(idx+1, t.finishNode(l))
+            t.startNode(l)
+        }
+        n := uint64(len(input))
+        if l.nodeBytes > 0 && n > l.nodeBytes-l.nodeFill {
+            n = l.nodeBytes - l.nodeFill
+        }
+        l.node.Update(input[:n])
+        l.nodeFill += n
+        l.processed += n
+        input = input[n:]
+    }
+}
+
+// Complete all levels of the tree and store the root chaining value in
+// state.
+//
+// The tree is done if a level contains exactly one chaining value or if
+// the node on the maximum tree height was completed.
+//
+func (t *skeinTree) final(state []uint64) {
+    blockBytes := uint64(t.parent.cipherStateWords * 8)
+    l := t.levels[0]
+    for idx := 1; ; idx++ {
+        t.finishNode(l)
+        if l.nodeBytes == 0 {
+            break
+        }
+        t.update(idx, l.cv)
+        if t.levels[idx].processed == blockBytes {
+            break
+        }
+        l = t.levels[idx]
+    }
+    copy(state, l.node.state)
+}
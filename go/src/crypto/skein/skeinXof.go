@@ -0,0 +1,243 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package skein
+
+import (
+    "io"
+    "math"
+    "strconv"
+    "sync"
+)
+
+// The output length in the configuration block of an extendable output
+// function. The maximum value marks an unbounded output, thus the XOF
+// output differs from all fixed size Skein hashes.
+const XOFOutputLength = ^uint64(0)
+
+// An XOF is Skein with extendable output.
+//
+// Applications write the message to the XOF and then read an unbounded
+// output stream. The first read or seek completes the message processing,
+// after that the XOF does not accept more data until it is reset.
+//
+// The Skein output stage computes each output block independently. Thus
+// XOF implements io.Seeker and io.ReaderAt to access the output stream at
+// any offset without computing the output before the offset.
+//
+type XOF struct {
+    skein   *Skein
+    chain   []uint64 // chaining value, input of the output stage
+    block   []byte   // the cached output block
+    blockNo uint64   // number of the cached output block
+    cached  bool     // true if block contains output block blockNo
+    offset  int64    // read position in the output stream
+    reading bool
+    mu      sync.Mutex
+    readers sync.Pool // *xofReader instances of ReadAt
+}
+
+// The output stage of a ReadAt call: a Skein instance for the Threefish
+// cipher and an output block.
+//
+type xofReader struct {
+    skein *Skein
+    block []byte
+}
+
+type xofWriteError int
+
+func (x xofWriteError) Error() string {
+    return "crypto/skein: write to XOF after read"
+}
+
+type xofOffsetError int64
+
+func (x xofOffsetError) Error() string {
+    return "crypto/skein: invalid XOF offset " + strconv.FormatInt(int64(x), 10)
+}
+
+type xofWhenceError int
+
+func (x xofWhenceError) Error() string {
+    return "crypto/skein: invalid XOF seek whence " + strconv.Itoa(int(x))
+}
+
+// Initializes a Skein XOF instance.
+//
+// stateSize
+//     The Skein state size in bits. Supported values are 256, 512,
+//     and 1024
+// key
+//     The key for a keyed XOF, may be nil
+//
+func NewXOF(stateSize int, key []byte) (*XOF, error) {
+    return NewXOFWithParams(&Params{StateSize: stateSize, Key: key})
+}
+
+// Initializes a Skein XOF instance with all parameters.
+//
+// The configuration block uses XOFOutputLength as output length,
+// NewXOFWithParams ignores the OutputSize parameter.
+//
+func NewXOFWithParams(p *Params) (*XOF, error) {
+    return newXOF(p, XOFOutputLength)
+}
+
+// Create an XOF, the configuration block uses configOutput as output
+// length in bits.
+//
+func newXOF(p *Params, configOutput uint64) (*XOF, error) {
+    q := *p
+    q.OutputSize = q.StateSize
+    s, err := newSkein(&q, configOutput)
+    if err != nil {
+        return nil, err
+    }
+    x := new(XOF)
+    x.skein = s
+    x.chain = make([]uint64, s.cipherStateWords)
+    x.block = make([]byte, s.cipherStateWords*8)
+    return x, nil
+}
+
+// Write adds more data to the message. Write returns an error if the
+// application already read output.
+//
+func (x *XOF) Write(p []byte) (int, error) {
+    if x.reading {
+        return 0, xofWriteError(0)
+    }
+    x.skein.Update(p)
+    return len(p), nil
+}
+
+// Complete the message processing if not yet done.
+//
+func (x *XOF) finish() {
+    x.mu.Lock()
+    if !x.reading {
+        x.skein.finalMessage()
+        copy(x.chain, x.skein.state)
+        x.reading = true
+    }
+    x.mu.Unlock()
+}
+
+// Limit p to the output before offset math.MaxInt64, the largest offset
+// of Seek and ReadAt. The error is io.EOF if p reaches past that offset.
+//
+func xofLimit(p []byte, off int64) ([]byte, error) {
+    if left := math.MaxInt64 - off; int64(len(p)) > left {
+        return p[:left], io.EOF
+    }
+    return p, nil
+}
+
+// Read reads output of the XOF at the current offset. Read returns
+// io.EOF only at the end of the addressable output, offset math.MaxInt64.
+//
+func (x *XOF) Read(p []byte) (int, error) {
+    x.finish()
+    p, err := xofLimit(p, x.offset)
+    blockBytes := int64(len(x.block))
+    n := 0
+    for n < len(p) {
+        blockNo := uint64(x.offset / blockBytes)
+        if !x.cached || x.blockNo != blockNo {
+            x.skein.outputBlock(x.chain, blockNo, x.block)
+            x.blockNo = blockNo
+            x.cached = true
+        }
+        c := copy(p[n:], x.block[x.offset%blockBytes:])
+        x.offset += int64(c)
+        n += c
+    }
+    return n, err
+}
+
+// ReadAt reads output of the XOF starting at offset off. It does not
+// change the offset used by Read. Like Read it returns io.EOF for output
+// past offset math.MaxInt64.
+//
+// Applications may call ReadAt from several goroutines in parallel,
+// each call uses its own Threefish instance. The XOF keeps these
+// instances for later calls, still each call computes at least one
+// output block. Applications that read the output in sequence should
+// use Read and Seek, they reuse the cached output block.
+//
+func (x *XOF) ReadAt(p []byte, off int64) (int, error) {
+    if off < 0 {
+        return 0, xofOffsetError(off)
+    }
+    x.finish()
+    r, _ := x.readers.Get().(*xofReader)
+    if r == nil {
+        stateSize := x.skein.cipherStateWords * 64
+        r = &xofReader{skein: new(Skein), block: make([]byte, len(x.block))}
+        r.skein.setup(stateSize, stateSize)
+    }
+    defer x.readers.Put(r)
+    p, err := xofLimit(p, off)
+    blockBytes := int64(len(r.block))
+
+    n := 0
+    for n < len(p) {
+        r.skein.outputBlock(x.chain, uint64(off/blockBytes), r.block)
+        c := copy(p[n:], r.block[off%blockBytes:])
+        off += int64(c)
+        n += c
+    }
+    return n, err
+}
+
+// Seek sets the offset for the next Read, see io.Seeker. The output
+// stream has no end below the largest offset math.MaxInt64, thus Seek
+// does not support io.SeekEnd.
+//
+// Seek completes the message processing.
+//
+func (x *XOF) Seek(offset int64, whence int) (int64, error) {
+    switch whence {
+    case io.SeekStart:
+    case io.SeekCurrent:
+        offset += x.offset
+    default:
+        return 0, xofWhenceError(whence)
+    }
+    if offset < 0 {
+        return 0, xofOffsetError(offset)
+    }
+    x.finish()
+    x.offset = offset
+    return offset, nil
+}
+
+// Reset resets the XOF to process a new message.
+//
+func (x *XOF) Reset() {
+    x.skein.Reset()
+    x.offset = 0
+    x.cached = false
+    x.reading = false
+}
+
+// BlockSize returns the Threefish state size in bytes.
+//
+func (x *XOF) BlockSize() int {
+    return x.skein.BlockSize()
+}
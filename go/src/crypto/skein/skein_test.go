@@ -20,10 +20,19 @@ package skein
 import (
 	"bufio"
 	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/threefish"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
+	"math"
+	"math/rand/v2"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -59,7 +68,36 @@ func checkKATVectors(ks *katScanner) bool {
 	var tree, mac, normal int
 
 	for ks.fillResult(kr) {
-		if strings.Contains(string(kr.restOfLine), "Tree") {
+		if idx := strings.Index(string(kr.restOfLine), "Tree:"); idx >= 0 {
+			var leaf, node, maxLevel int
+			fmt.Sscanf(string(kr.restOfLine[idx:]), "Tree: leaf=%x, node=%x, maxLevels=%x",
+				&leaf, &node, &maxLevel)
+			skein, err := NewExtended(kr.stateSize, kr.hashBitLength,
+				TreeInfo(leaf, node, maxLevel), nil)
+			if err != nil {
+				fmt.Printf("Cannot create tree hash: %s\n", err)
+				return false
+			}
+			skein.UpdateBits(kr.msg, kr.msgLength)
+			hash := skein.DoFinal()
+			if ret := bytes.Compare(hash, kr.result); ret != 0 {
+				fmt.Printf("%d-%d-%d-%s\n", kr.stateSize, kr.hashBitLength,
+					kr.msgLength, string(kr.restOfLine))
+				fmt.Printf("Computed tree hash:\n%s\n", hex.EncodeToString(hash))
+				fmt.Printf("Expected result:\n%s\n", hex.EncodeToString(kr.result))
+				return false
+			}
+			// do it second time with same instance to check if tree was
+			// reset correctly
+			skein.UpdateBits(kr.msg, kr.msgLength)
+			hash = skein.DoFinal()
+			if ret := bytes.Compare(hash, kr.result); ret != 0 {
+				fmt.Printf("%d-%d-%d-%s\n", kr.stateSize, kr.hashBitLength,
+					kr.msgLength, string(kr.restOfLine))
+				fmt.Printf("Computed tree hash after reset:\n%s\n", hex.EncodeToString(hash))
+				fmt.Printf("Expected result:\n%s\n", hex.EncodeToString(kr.result))
+				return false
+			}
 			tree++
 			continue
 		}
@@ -111,8 +149,8 @@ func checkKATVectors(ks *katScanner) bool {
 		}
 		normal++
 	}
-	fmt.Printf("(Tree: %d), mac: %d, normal: %d, Skein tests total: %d\n",
-		tree, mac, normal, mac+normal)
+	fmt.Printf("Tree: %d, mac: %d, normal: %d, Skein tests total: %d\n",
+		tree, mac, normal, tree+mac+normal)
 	return true
 }
 
@@ -267,7 +305,7 @@ func (s *katScanner) parseResultLine(line string, kr *katResult) {
 		kr.resultFill++
 	}
 	if err != nil && ret <= 0 {
-		fmt.Printf("result: %s, ret: %d, %s \n", hex.EncodeToString(kr.result))
+		fmt.Printf("result: %s, ret: %d, %s \n", hex.EncodeToString(kr.result), ret, err)
 	}
 }
 
@@ -302,3 +340,695 @@ func (s *katScanner) parseMacKeyHeaderLine(line string, kr *katResult) {
 	}
 	s.state = MacKey
 }
+
+func TestTreeChunked(t *testing.T) {
+	msg := make([]byte, 5000)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	for _, stateSize := range []int{256, 512, 1024} {
+		for _, info := range []int{TreeInfo(1, 1, 255), TreeInfo(1, 2, 3), TreeInfo(2, 1, 2)} {
+			skein, _ := NewExtended(stateSize, stateSize, info, nil)
+			skein.Update(msg)
+			expected := skein.DoFinal()
+
+			for _, chunk := range []int{1, 7, 64, 1000} {
+				for i := 0; i < len(msg); i += chunk {
+					end := i + chunk
+					if end > len(msg) {
+						end = len(msg)
+					}
+					skein.Update(msg[i:end])
+				}
+				if hash := skein.DoFinal(); !bytes.Equal(hash, expected) {
+					t.Errorf("tree %x, state %d, chunk %d: got %x, expected %x",
+						info, stateSize, chunk, hash, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestTreeInfoError(t *testing.T) {
+	for _, info := range []int{TreeInfo(0, 1, 2), TreeInfo(1, 0, 2), TreeInfo(1, 1, 1), 1 << 24} {
+		if _, err := NewExtended(512, 512, info, nil); err == nil {
+			t.Errorf("tree info %x: expected an error", info)
+		}
+	}
+}
+
+func TestHashReaderAt(t *testing.T) {
+	saved := parallelBufferSize
+	defer func() { parallelBufferSize = saved }()
+
+	msg := make([]byte, 20000)
+	for i := range msg {
+		msg[i] = byte(i * 7)
+	}
+	for _, bufSize := range []int{100, 1000, 1 << 20} {
+		parallelBufferSize = bufSize
+		for _, stateSize := range []int{256, 512, 1024} {
+			for _, info := range []int{0, TreeInfo(1, 1, 255), TreeInfo(2, 2, 2), TreeInfo(5, 1, 3)} {
+				skein, _ := NewExtended(stateSize, 512, info, nil)
+				for _, size := range []int{0, 1, 63, 64, 128, 1000, 4096, 20000} {
+					skein.Update(msg[:size])
+					expected := skein.DoFinal()
+					for _, workers := range []int{0, 1, 3, 8} {
+						hash, err := skein.HashReaderAt(bytes.NewReader(msg), int64(size), workers)
+						if err != nil {
+							t.Fatalf("unexpected error: %s", err)
+						}
+						if !bytes.Equal(hash, expected) {
+							t.Errorf("tree %x, state %d, size %d, workers %d: got %x, expected %x",
+								info, stateSize, size, workers, hash, expected)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestHashReaderAtShort(t *testing.T) {
+	skein, _ := NewExtended(512, 512, TreeInfo(1, 1, 255), nil)
+	if _, err := skein.HashReaderAt(bytes.NewReader(make([]byte, 1000)), 2000, 2); err == nil {
+		t.Error("expected an error for short data")
+	}
+}
+
+// A simple UBI implementation, independent of the Skein type, to check
+// the processing of the optional arguments.
+func ubi(g []uint64, msg []byte, blockType int, tweak1 uint64) []uint64 {
+	blockBytes := len(g) * 8
+	h := append([]uint64(nil), g...)
+	block := make([]uint64, len(g))
+	out := make([]uint64, len(g))
+	pos := 0
+	for first := true; first || pos < len(msg); first = false {
+		n := len(msg) - pos
+		if n > blockBytes {
+			n = blockBytes
+		}
+		buf := make([]byte, blockBytes)
+		copy(buf, msg[pos:pos+n])
+		pos += n
+		for i := range block {
+			block[i] = binary.LittleEndian.Uint64(buf[i*8:])
+		}
+		t1 := uint64(blockType)<<56 | tweak1
+		if first {
+			t1 |= 1 << 62
+		}
+		if pos == len(msg) {
+			t1 |= 1 << 63
+		}
+		c, _ := threefish.New64(h, []uint64{uint64(pos), t1})
+		c.Encrypt64(out, block)
+		for i := range h {
+			h[i] = out[i] ^ block[i]
+		}
+	}
+	return h
+}
+
+func TestOptionalArguments(t *testing.T) {
+	p := &Params{
+		StateSize:       512,
+		OutputSize:      512,
+		Schema:          []byte("TEST"),
+		Key:             []byte("key"),
+		Personalization: []byte("20111016 someone@example.com skeintest"),
+		PublicKey:       bytes.Repeat([]byte{0x55}, 100),
+		KeyIdentifier:   []byte("key id"),
+		Nonce:           []byte("nonce"),
+	}
+	msg := []byte("The quick brown fox jumps over the lazy dog")
+
+	g := make([]uint64, 8)
+	g = ubi(g, p.Key, Key, 0)
+	conf := make([]byte, 32)
+	copy(conf, p.Schema)
+	conf[4] = 1
+	binary.LittleEndian.PutUint64(conf[8:], uint64(p.OutputSize))
+	g = ubi(g, conf, Config, 0)
+	g = ubi(g, p.Personalization, Personalization, 0)
+	g = ubi(g, p.PublicKey, PublicKey, 0)
+	g = ubi(g, p.KeyIdentifier, KeyIdentifier, 0)
+	g = ubi(g, p.Nonce, Nonce, 0)
+	g = ubi(g, msg, Message, 0)
+	g = ubi(g, make([]byte, 8), Out, 0)
+	expected := make([]byte, 64)
+	for i := range g {
+		binary.LittleEndian.PutUint64(expected[i*8:], g[i])
+	}
+
+	skein, err := NewWithParams(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		skein.Update(msg)
+		if hash := skein.DoFinal(); !bytes.Equal(hash, expected) {
+			t.Errorf("round %d: got %x, expected %x", i, hash, expected)
+		}
+	}
+
+	// Without optional arguments the result is the plain Skein-MAC
+	g = ubi(make([]uint64, 4), p.Key, Key, 0)
+	conf = make([]byte, 32)
+	copy(conf, schema[:])
+	conf[4] = 1
+	binary.LittleEndian.PutUint64(conf[8:], 256)
+	g = ubi(g, conf, Config, 0)
+	g = ubi(g, msg, Message, 0)
+	g = ubi(g, make([]byte, 8), Out, 0)
+	expected = make([]byte, 32)
+	for i := range g {
+		binary.LittleEndian.PutUint64(expected[i*8:], g[i])
+	}
+	skein, _ = NewWithParams(&Params{StateSize: 256, OutputSize: 256, Key: p.Key})
+	skein.Update(msg)
+	if hash := skein.DoFinal(); !bytes.Equal(hash, expected) {
+		t.Errorf("got %x, expected %x", hash, expected)
+	}
+
+	if _, err := NewWithParams(&Params{StateSize: 512, OutputSize: 512, Schema: []byte("SHA")}); err == nil {
+		t.Error("expected an error for an invalid schema")
+	}
+}
+
+func TestHashInterface(t *testing.T) {
+	msg := []byte("The quick brown fox jumps over the lazy dog")
+	for _, info := range []int{0, TreeInfo(1, 1, 255)} {
+		skein, _ := NewExtended(512, 256, info, nil)
+		if bs := skein.BlockSize(); bs != 64 {
+			t.Errorf("block size %d, expected 64", bs)
+		}
+		skein.Write(msg)
+		expected := skein.DoFinal()
+
+		skein.Write(msg[:10])
+		prefix := []byte("prefix")
+		sum := skein.Sum(prefix)
+		if !bytes.HasPrefix(sum, prefix) || len(sum) != len(prefix)+skein.Size() {
+			t.Errorf("Sum does not append: %x", sum)
+		}
+		if again := skein.Sum(nil); !bytes.Equal(again, sum[len(prefix):]) {
+			t.Errorf("second Sum differs: %x, %x", again, sum[len(prefix):])
+		}
+		skein.Write(msg[10:])
+		if hash := skein.Sum(nil); !bytes.Equal(hash, expected) {
+			t.Errorf("tree %x: Sum changed the state: got %x, expected %x", info, hash, expected)
+		}
+		skein.Reset()
+		skein.Write(msg)
+		if hash := skein.Sum(nil); !bytes.Equal(hash, expected) {
+			t.Errorf("tree %x: Reset failed: got %x, expected %x", info, hash, expected)
+		}
+	}
+
+	variants := []struct {
+		new                   func() hash.Hash
+		stateSize, outputSize int
+	}{
+		{New224, 512, 224}, {New256, 512, 256}, {New384, 512, 384},
+		{New512, 512, 512}, {New1024, 1024, 1024},
+		{New256_128, 256, 128}, {New256_160, 256, 160}, {New256_224, 256, 224},
+		{New256_256, 256, 256}, {New512_128, 512, 128}, {New512_160, 512, 160},
+		{New512_224, 512, 224}, {New512_256, 512, 256}, {New512_384, 512, 384},
+		{New512_512, 512, 512}, {New1024_384, 1024, 384}, {New1024_512, 1024, 512},
+		{New1024_1024, 1024, 1024},
+	}
+	for _, v := range variants {
+		h := v.new()
+		if h.Size() != v.outputSize/8 || h.BlockSize() != v.stateSize/8 {
+			t.Errorf("Skein-%d-%d: size %d, block size %d", v.stateSize, v.outputSize,
+				h.Size(), h.BlockSize())
+		}
+		skein, _ := New(v.stateSize, v.outputSize)
+		skein.Update(msg)
+		h.Write(msg)
+		if hash, expected := h.Sum(nil), skein.DoFinal(); !bytes.Equal(hash, expected) {
+			t.Errorf("Skein-%d-%d: got %x, expected %x", v.stateSize, v.outputSize, hash, expected)
+		}
+	}
+
+	// crypto/hmac relies on BlockSize, Sum, and Reset
+	key := []byte("key")
+	mac := hmac.New(New512, key)
+	mac.Write(msg)
+	tag := mac.Sum(nil)
+	mac.Reset()
+	mac.Write(msg[:5])
+	mac.Write(msg[5:])
+	if again := mac.Sum(nil); !hmac.Equal(tag, again) {
+		t.Errorf("HMAC differs: %x, %x", tag, again)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	msg := make([]byte, 3000)
+	for i := range msg {
+		msg[i] = byte(i * 3)
+	}
+	for _, stateSize := range []int{256, 512, 1024} {
+		for _, info := range []int{0, TreeInfo(1, 1, 255), TreeInfo(1, 2, 2)} {
+			skein, _ := NewWithParams(&Params{StateSize: stateSize, OutputSize: 200,
+				TreeInfo: info, Key: []byte("key"), Personalization: []byte("marshal")})
+			skein.Update(msg)
+			expected := skein.DoFinal()
+
+			for _, split := range []int{0, 1, 64, 129, 1000, 3000} {
+				skein.Update(msg[:split])
+				clone := skein.Clone()
+				data, err := skein.MarshalBinary()
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				restored := new(Skein)
+				if err := restored.UnmarshalBinary(data); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				for _, h := range []*Skein{skein, clone, restored} {
+					h.Update(msg[split:])
+					if hash := h.DoFinal(); !bytes.Equal(hash, expected) {
+						t.Errorf("state %d, tree %x, split %d: got %x, expected %x",
+							stateSize, info, split, hash, expected)
+					}
+				}
+				// A restored context must survive a reset
+				restored.Update(msg)
+				if hash := restored.DoFinal(); !bytes.Equal(hash, expected) {
+					t.Errorf("state %d, tree %x: restored context failed after reset", stateSize, info)
+				}
+				for i := range data {
+					if err := restored.UnmarshalBinary(data[:i]); err == nil {
+						t.Fatalf("state %d, tree %x: accepted truncated data", stateSize, info)
+					}
+				}
+			}
+		}
+	}
+
+	mac, _ := NewMac(512, 512, []byte("mac key"))
+	mac.Update(msg)
+	expected := mac.DoFinal()
+	mac.Update(msg[:100])
+	data, _ := mac.MarshalBinary()
+	restored := new(SkeinMac)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	clone := mac.Clone()
+	for _, m := range []*SkeinMac{restored, clone} {
+		m.Update(msg[100:])
+		if hash := m.DoFinal(); !bytes.Equal(hash, expected) {
+			t.Errorf("MAC: got %x, expected %x", hash, expected)
+		}
+		m.Update(msg)
+		if hash := m.DoFinal(); !bytes.Equal(hash, expected) {
+			t.Errorf("MAC after reset: got %x, expected %x", hash, expected)
+		}
+	}
+	if err := new(Skein).UnmarshalBinary(data); err == nil {
+		t.Error("Skein accepted Skein MAC state")
+	}
+}
+
+func TestMacHash(t *testing.T) {
+	msg := []byte("The quick brown fox jumps over the lazy dog")
+	mac, _ := NewMac(512, 256, []byte("mac key"))
+	var h hash.Hash = mac
+	if h.Size() != 32 || h.BlockSize() != 64 {
+		t.Errorf("size %d, block size %d", h.Size(), h.BlockSize())
+	}
+	mac.Update(msg)
+	expected := mac.DoFinal()
+
+	h.Write(msg[:7])
+	h.Write(msg[7:])
+	tag := h.Sum(nil)
+	if !bytes.Equal(tag, expected) {
+		t.Errorf("got %x, expected %x", tag, expected)
+	}
+	if !mac.Verify(tag) || !mac.Verify(tag[:MinTagSize]) || !mac.Verify(tag[:16]) {
+		t.Error("Verify rejected a valid tag")
+	}
+	bad := append([]byte(nil), tag...)
+	bad[len(bad)-1] ^= 1
+	if mac.Verify(bad) || mac.Verify(tag[:MinTagSize-1]) || mac.Verify(nil) ||
+		mac.Verify(append(tag, 0)) {
+		t.Error("Verify accepted an invalid tag")
+	}
+	h.Reset()
+	h.Write(msg)
+	if !mac.Verify(expected) {
+		t.Error("Verify failed after Reset")
+	}
+	if !Equal([]byte{1, 2}, []byte{1, 2}) || Equal([]byte{1, 2, 3, 4, 5}, []byte{1, 2}) {
+		t.Error("Equal failed")
+	}
+}
+
+func TestXOF(t *testing.T) {
+	msg := []byte("The quick brown fox jumps over the lazy dog")
+	for _, stateSize := range []int{256, 512, 1024} {
+		xof, err := NewXOF(stateSize, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		xof.Write(msg)
+		long := make([]byte, 1000)
+		xof.Read(long)
+		if _, err := xof.Write(msg); err == nil {
+			t.Error("expected an error for write after read")
+		}
+
+		// Output must not depend on the read sizes
+		xof.Reset()
+		xof.Write(msg)
+		out := make([]byte, 0, len(long))
+		for i := 1; len(out) < len(long); i++ {
+			buf := make([]byte, i)
+			if len(out)+i > len(long) {
+				buf = buf[:len(long)-len(out)]
+			}
+			xof.Read(buf)
+			out = append(out, buf...)
+		}
+		if !bytes.Equal(out, long) {
+			t.Errorf("state %d: output depends on read sizes", stateSize)
+		}
+
+		// The output stage is the same as for a hash with the XOF output
+		// length in the configuration
+		skein, _ := newSkein(&Params{StateSize: stateSize, OutputSize: 8000}, XOFOutputLength)
+		skein.Update(msg)
+		if hash := skein.DoFinal(); !bytes.Equal(hash, long) {
+			t.Errorf("state %d: got %x, expected %x", stateSize, long, hash)
+		}
+		fixed, _ := New(stateSize, 8000)
+		fixed.Update(msg)
+		if bytes.Equal(fixed.DoFinal(), long) {
+			t.Errorf("state %d: XOF output equals fixed size hash", stateSize)
+		}
+	}
+}
+
+func TestXOFRandomAccess(t *testing.T) {
+	msg := []byte("random access")
+	xof, _ := NewXOF(512, []byte("key"))
+	xof.Write(msg)
+	stream := make([]byte, 2000)
+	xof.Read(stream)
+
+	for _, off := range []int{0, 1, 63, 64, 65, 500, 1234} {
+		for _, n := range []int{1, 10, 64, 200, 700} {
+			if off+n > len(stream) {
+				continue
+			}
+			buf := make([]byte, n)
+			if _, err := xof.ReadAt(buf, int64(off)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(buf, stream[off:off+n]) {
+				t.Errorf("ReadAt(%d, %d): wrong output", off, n)
+			}
+			if pos, err := xof.Seek(int64(off), io.SeekStart); err != nil || pos != int64(off) {
+				t.Fatalf("Seek(%d): %d, %v", off, pos, err)
+			}
+			xof.Read(buf)
+			if !bytes.Equal(buf, stream[off:off+n]) {
+				t.Errorf("Seek(%d), Read(%d): wrong output", off, n)
+			}
+		}
+	}
+	xof.Seek(100, io.SeekStart)
+	if pos, _ := xof.Seek(-40, io.SeekCurrent); pos != 60 {
+		t.Errorf("SeekCurrent: got %d, expected 60", pos)
+	}
+	if _, err := xof.Seek(0, io.SeekEnd); err == nil {
+		t.Error("expected an error for SeekEnd")
+	}
+	if _, err := xof.Seek(-1, io.SeekStart); err == nil {
+		t.Error("expected an error for a negative offset")
+	}
+
+	// The output ends at offset math.MaxInt64
+	end := int64(math.MaxInt64)
+	last := make([]byte, 10)
+	if pos, err := xof.Seek(end-10, io.SeekStart); err != nil || pos != end-10 {
+		t.Fatalf("Seek(%d): %d, %v", end-10, pos, err)
+	}
+	if n, err := xof.Read(last); n != 10 || err != nil {
+		t.Errorf("Read before the end: %d, %v", n, err)
+	}
+	if n, err := xof.Read(last); n != 0 || err != io.EOF {
+		t.Errorf("Read at the end: %d, %v", n, err)
+	}
+	if _, err := xof.Seek(1, io.SeekCurrent); err == nil {
+		t.Error("expected an error for an offset past the end")
+	}
+	buf := make([]byte, 200)
+	if n, err := xof.ReadAt(buf, end-10); n != 10 || err != io.EOF || !bytes.Equal(buf[:10], last) {
+		t.Errorf("ReadAt across the end: %d, %v", n, err)
+	}
+	if n, err := xof.ReadAt(buf, end); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt at the end: %d, %v", n, err)
+	}
+
+	// Parallel ReadAt
+	parts := make([][]byte, 8)
+	var wg sync.WaitGroup
+	for i := range parts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			parts[i] = make([]byte, 250)
+			xof.ReadAt(parts[i], int64(i*250))
+		}(i)
+	}
+	wg.Wait()
+	if !bytes.Equal(bytes.Join(parts, nil), stream) {
+		t.Error("parallel ReadAt: wrong output")
+	}
+
+	// ReadAt reuses its instances after Reset
+	xof.Reset()
+	xof.Write([]byte("another message"))
+	other, _ := NewXOF(512, []byte("key"))
+	other.Write([]byte("another message"))
+	other.Read(stream)
+	buf = make([]byte, 300)
+	xof.ReadAt(buf, 1000)
+	if !bytes.Equal(buf, stream[1000:1300]) {
+		t.Error("ReadAt after Reset: wrong output")
+	}
+}
+
+func TestStreamCipher(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	nonce := []byte("nonce")
+	plain := make([]byte, 5000)
+	for i := range plain {
+		plain[i] = byte(i)
+	}
+	for _, stateSize := range []int{256, 512, 1024} {
+		var stream cipher.Stream
+		stream, err := NewStreamCipher(key, nonce, stateSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		enc := make([]byte, len(plain))
+		stream.XORKeyStream(enc, plain)
+
+		// The key stream is the output of Skein with key and nonce
+		xof, _ := NewXOFWithParams(&Params{StateSize: stateSize, Key: key, Nonce: nonce})
+		keyStream := make([]byte, len(plain))
+		xof.Read(keyStream)
+		for i := range enc {
+			if enc[i] != plain[i]^keyStream[i] {
+				t.Fatalf("state %d: wrong key stream at %d", stateSize, i)
+			}
+		}
+
+		// Decrypt in place in several parts
+		dec, _ := NewStreamCipher(key, nonce, stateSize)
+		buf := append([]byte(nil), enc...)
+		for i, n := 0, 1; i < len(buf); i, n = i+n, n*3 {
+			end := i + n
+			if end > len(buf) {
+				end = len(buf)
+			}
+			dec.XORKeyStream(buf[i:end], buf[i:end])
+		}
+		if !bytes.Equal(buf, plain) {
+			t.Errorf("state %d: decryption failed", stateSize)
+		}
+
+		dec.Seek(1000, io.SeekStart)
+		part := make([]byte, 100)
+		dec.XORKeyStream(part, enc[1000:1100])
+		if !bytes.Equal(part, plain[1000:1100]) {
+			t.Errorf("state %d: decryption after seek failed", stateSize)
+		}
+
+		other, _ := NewStreamCipher(key, []byte("other nonce"), stateSize)
+		other.XORKeyStream(buf, plain)
+		if bytes.Equal(buf, enc) {
+			t.Errorf("state %d: nonce has no effect", stateSize)
+		}
+	}
+	if _, err := NewStreamCipher(nil, nonce, 512); err == nil {
+		t.Error("expected an error for an empty key")
+	}
+}
+
+var kdfVectors = []struct {
+	stateSize int
+	master    string
+	keyID     string
+	length    int64
+	result    string
+}{
+	{256, "master key", "", 32,
+		"ca9325fb583151bcb8436ab3f2e86468d1204b94367a3f0b5b4c73f757233b68"},
+	{512, "master key", "service A, encryption key", 32,
+		"98f703d3bcf29812151573c833ce651e5dc2878daa459002dc0747da9b2715c0"},
+	{512, "master key", "service A, mac key", 64,
+		"3e58e8bf4afb4f4f790dbf646347ae0332ad8589d4755f69ca14eecd04b741f8321daa74e426c81e282f0549d742f74547ec2620277dbca9b524dae59308319c"},
+	{1024, "master key", "service B", 160,
+		"c3d8b0c7447b0e9122d0d316d688594655117e568ebf9926fbc6dd2139571e9d29443562e979871ef9365a8aeee106eabe2d7456cbe8a8a147fc0bab77573fc9eace92c12076be174c80a83ff438a4a520777add9347e557c72e426b54ff59f0e5bb154feb7c6c52dafd3db158d12307ae5687a061d74d5298ef7c443a4868ef6b171d2f3ab96cd4d14a9bb631be7dd8b58ed2aae360db1a6076b70da1ddc557"},
+}
+
+func TestKDF(t *testing.T) {
+	master := []byte("master key")
+	keyID := []byte("service A, encryption key")
+	for _, stateSize := range []int{256, 512, 1024} {
+		for _, length := range []int64{16, 32, 100, 300} {
+			words := stateSize / 64
+			g := ubi(make([]uint64, words), master, Key, 0)
+			conf := make([]byte, 32)
+			copy(conf, "SHA3")
+			conf[4] = 1
+			binary.LittleEndian.PutUint64(conf[8:], uint64(length*8))
+			g = ubi(g, conf, Config, 0)
+			g = ubi(g, keyID, KeyIdentifier, 0)
+			g = ubi(g, nil, Message, 0)
+			var expected []byte
+			for i := 0; int64(len(expected)) < length; i++ {
+				counter := make([]byte, 8)
+				binary.LittleEndian.PutUint64(counter, uint64(i))
+				for _, w := range ubi(g, counter, Out, 0) {
+					expected = binary.LittleEndian.AppendUint64(expected, w)
+				}
+			}
+			expected = expected[:length]
+
+			kdf, err := NewKDF(stateSize, master, keyID, length)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var out []byte
+			buf := make([]byte, 7)
+			for {
+				n, err := kdf.Read(buf)
+				out = append(out, buf[:n]...)
+				if err == io.EOF {
+					break
+				}
+			}
+			if !bytes.Equal(out, expected) {
+				t.Errorf("state %d, length %d: got %x, expected %x", stateSize, length, out, expected)
+			}
+		}
+	}
+	for _, v := range kdfVectors {
+		kdf, _ := NewKDF(v.stateSize, []byte(v.master), []byte(v.keyID), v.length)
+		out := make([]byte, v.length)
+		kdf.Read(out)
+		if hex.EncodeToString(out) != v.result {
+			t.Errorf("Skein-%d KDF %q, %q: got %x, expected %s", v.stateSize, v.master,
+				v.keyID, out, v.result)
+		}
+	}
+	key, _ := DeriveKey(master, keyID, 32)
+	kdf, _ := NewKDF(512, master, keyID, 32)
+	expected := make([]byte, 32)
+	kdf.Read(expected)
+	if !bytes.Equal(key, expected) {
+		t.Errorf("DeriveKey: got %x, expected %x", key, expected)
+	}
+	if _, err := DeriveKey(master, keyID, 0); err == nil {
+		t.Error("expected an error for zero length")
+	}
+}
+
+func TestPRNG(t *testing.T) {
+	seed := []byte("reproducible seed for the simulator")
+	for _, stateSize := range []int{256, 512, 1024} {
+		p1, err := NewPRNG(stateSize, seed)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		p2, _ := NewPRNG(stateSize, seed)
+		a := make([]byte, 300)
+		b := make([]byte, 300)
+		p1.Read(a)
+		p2.Read(b)
+		if !bytes.Equal(a, b) {
+			t.Errorf("state %d: seeded PRNGs differ", stateSize)
+		}
+		// Each request rekeys, thus the next request differs
+		p1.Read(b)
+		if bytes.Equal(a, b) {
+			t.Errorf("state %d: PRNG repeats output", stateSize)
+		}
+
+		// The output follows the Skein output stage on the state
+		state := make([]uint64, stateSize/64)
+		h, _ := New(stateSize, stateSize)
+		h.Update(make([]byte, stateSize/8))
+		h.Update(seed)
+		for i, w := range h.DoFinal() {
+			state[i/8] |= uint64(w) << (8 * uint(i%8))
+		}
+		p3, _ := NewPRNG(stateSize, seed)
+		r := p3.Uint64()
+		counter := make([]byte, 8)
+		counter[0] = 1
+		expected := ubi(state, counter, Out, 0)[0]
+		if r != expected {
+			t.Errorf("state %d: got %x, expected %x", stateSize, r, expected)
+		}
+
+		p1.Reseed([]byte("more entropy"))
+		p2.Read(b)
+		p1.Read(a)
+		if bytes.Equal(a, b) {
+			t.Errorf("state %d: reseed has no effect", stateSize)
+		}
+	}
+
+	p, _ := NewPRNG(512, seed)
+	rng := rand.New(p)
+	first := rng.IntN(1000)
+	p, _ = NewPRNG(512, seed)
+	if again := rand.New(p).IntN(1000); again != first {
+		t.Errorf("math/rand: got %d, expected %d", again, first)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 100)
+			for j := 0; j < 100; j++ {
+				p.Read(buf)
+				p.Uint64()
+			}
+		}()
+	}
+	wg.Wait()
+}
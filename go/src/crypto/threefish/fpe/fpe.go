@@ -0,0 +1,356 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements format-preserving encryption with Threefish.
+//
+// The mode encrypts a string of n numerals in radix r to another string
+// of n numerals in radix r, for example a credit card number to another
+// digit string. It uses the Feistel structure of NIST SP 800-38G FF1 with
+// ten rounds: the numeral string is split into the halves A (n/2 numerals)
+// and B and each round computes
+//
+//    C = (NUM(A) + PRF(i, B)) mod r^m
+//    A = B
+//    B = C
+//
+// where m is the length of A. The round function PRF is Threefish in a
+// CBC-MAC like chain that carries the round number and the user tweak in
+// the Threefish tweak. All Threefish tweaks have the control word
+//
+//    bits 0 - 7:   round number i
+//    bits 8 - 15:  domain, 1 tweak, 2 data, 3 output
+//    bits 16 - 63: block index j
+//
+// as tweak[0]. Starting with an all zero block Y the PRF
+//
+//  - encrypts Y with tweak[1] set to the 8 byte chunk j of the user
+//    tweak, little endian and padded with zero bytes,
+//  - encrypts Y xor data block j with tweak[1] = r | n << 17 | len(T) << 49,
+//    the data is NUM(B) as big endian number in b bytes, padded with
+//    leading zero bytes to a multiple of the block size,
+//  - uses Y followed by the encryptions of Y with the output domain and
+//    block index 1, 2, ... as output. PRF(i, B) is the big endian number
+//    in the first d bytes of the output.
+//
+// The numbers b and d are computed as in FF1. The domain size r^n must be
+// at least one million.
+//
+package fpe
+
+import (
+    "crypto/threefish"
+    "encoding/binary"
+    "math/big"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+const (
+    MinRadix     = 2
+    MaxRadix     = 65536
+    MaxLength    = 65536     // Maximum number of numerals
+    MaxTweakSize = 1<<15 - 1 // Maximum size of the user tweak in bytes
+
+    // Minimum size of the domain radix^length
+    MinDomainSize = 1000000
+
+    rounds = 10
+)
+
+// Threefish tweak domains
+const (
+    domainTweak  = 1
+    domainData   = 2
+    domainOutput = 3
+)
+
+// The alphabet of the string functions
+const digits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// A Cipher is an instance of the format-preserving encryption using a
+// particular key and radix.
+//
+// All functions are safe for concurrent use by several goroutines.
+//
+type Cipher struct {
+    mu     sync.Mutex
+    cipher *threefish.Cipher
+    radix  int
+    tweak  [2]uint64
+    y      []byte
+    block  []byte
+}
+
+type RadixError int
+
+func (r RadixError) Error() string {
+    return "crypto/threefish/fpe: invalid radix " + strconv.Itoa(int(r))
+}
+
+type LengthError int
+
+func (l LengthError) Error() string {
+    return "crypto/threefish/fpe: invalid numeral string length " + strconv.Itoa(int(l))
+}
+
+type numeralError int
+
+func (n numeralError) Error() string {
+    return "crypto/threefish/fpe: numeral out of range at position " + strconv.Itoa(int(n))
+}
+
+type tweakError int
+
+func (t tweakError) Error() string {
+    return "crypto/threefish/fpe: tweak too long " + strconv.Itoa(int(t))
+}
+
+// New creates and returns a format-preserving Cipher.
+//
+// key
+//      Key data, the key length of 32, 64 or 128 bytes selects the
+//      Threefish state size
+// radix
+//      The radix of the numerals, MinRadix to MaxRadix
+//
+func New(key []byte, radix int) (*Cipher, error) {
+    if radix < MinRadix || radix > MaxRadix {
+        return nil, RadixError(radix)
+    }
+    tf, err := threefish.New(key, nil)
+    if err != nil {
+        return nil, err
+    }
+    c := new(Cipher)
+    c.cipher = tf
+    c.radix = radix
+    c.y = make([]byte, tf.BlockSize())
+    c.block = make([]byte, tf.BlockSize())
+    return c, nil
+}
+
+// Radix returns the radix of the numerals.
+//
+func (c *Cipher) Radix() int {
+    return c.radix
+}
+
+// Encrypt a numeral string.
+// Dst and src may point at the same memory.
+//
+// dst
+//      Destination of the encrypted numerals, at least as long as src
+// src
+//      The numerals, each less than the radix
+// tweak
+//      The user tweak, at most MaxTweakSize bytes
+//
+func (c *Cipher) Encrypt(dst, src []uint16, tweak []byte) error {
+    return c.crypt(dst, src, c.radix, tweak, false)
+}
+
+// Decrypt a numeral string.
+// Dst and src may point at the same memory.
+//
+// dst
+//      Destination of the decrypted numerals, at least as long as src
+// src
+//      The encrypted numerals
+// tweak
+//      The user tweak used to encrypt the numerals
+//
+func (c *Cipher) Decrypt(dst, src []uint16, tweak []byte) error {
+    return c.crypt(dst, src, c.radix, tweak, true)
+}
+
+// EncryptString encrypts a string of digits and lower case letters, the
+// first radix characters of 0-9a-z. The radix must not exceed 36.
+//
+func (c *Cipher) EncryptString(s string, tweak []byte) (string, error) {
+    return c.cryptString(s, tweak, false)
+}
+
+// DecryptString decrypts a string encrypted by EncryptString.
+//
+func (c *Cipher) DecryptString(s string, tweak []byte) (string, error) {
+    return c.cryptString(s, tweak, true)
+}
+
+func (c *Cipher) cryptString(s string, tweak []byte, decrypt bool) (string, error) {
+    if c.radix > len(digits) {
+        return "", RadixError(c.radix)
+    }
+    x := make([]uint16, len(s))
+    for i := 0; i < len(s); i++ {
+        d := strings.IndexByte(digits[:c.radix], s[i])
+        if d < 0 {
+            return "", numeralError(i)
+        }
+        x[i] = uint16(d)
+    }
+    if err := c.crypt(x, x, c.radix, tweak, decrypt); err != nil {
+        return "", err
+    }
+    b := make([]byte, len(x))
+    for i, d := range x {
+        b[i] = digits[d]
+    }
+    return string(b), nil
+}
+
+// EncryptUint64 encrypts a 64 bit integer to another 64 bit integer.
+//
+// The function uses four numerals in radix 65536, independent of the
+// Cipher's radix.
+//
+func (c *Cipher) EncryptUint64(x uint64, tweak []byte) (uint64, error) {
+    return c.cryptUint64(x, tweak, false)
+}
+
+// DecryptUint64 decrypts a 64 bit integer encrypted by EncryptUint64.
+//
+func (c *Cipher) DecryptUint64(x uint64, tweak []byte) (uint64, error) {
+    return c.cryptUint64(x, tweak, true)
+}
+
+func (c *Cipher) cryptUint64(x uint64, tweak []byte, decrypt bool) (uint64, error) {
+    n := []uint16{uint16(x >> 48), uint16(x >> 32), uint16(x >> 16), uint16(x)}
+    if err := c.crypt(n, n, MaxRadix, tweak, decrypt); err != nil {
+        return 0, err
+    }
+    return uint64(n[0])<<48 | uint64(n[1])<<32 | uint64(n[2])<<16 | uint64(n[3]), nil
+}
+
+// Check the parameters, en- or decrypt src to dst.
+//
+func (c *Cipher) crypt(dst, src []uint16, radix int, tweak []byte, decrypt bool) error {
+    n := len(src)
+    if len(dst) < n {
+        panic("crypto/threefish/fpe: output smaller than input")
+    }
+    if len(tweak) > MaxTweakSize {
+        return tweakError(len(tweak))
+    }
+    r := big.NewInt(int64(radix))
+    if n < 2 || n > MaxLength ||
+        new(big.Int).Exp(r, big.NewInt(int64(n)), nil).Cmp(big.NewInt(MinDomainSize)) < 0 {
+        return LengthError(n)
+    }
+    for i, d := range src {
+        if int(d) >= radix {
+            return numeralError(i)
+        }
+    }
+
+    u := n / 2
+    v := n - u
+    modU := new(big.Int).Exp(r, big.NewInt(int64(u)), nil)
+    modV := new(big.Int).Exp(r, big.NewInt(int64(v)), nil)
+    b := (new(big.Int).Sub(modV, big.NewInt(1)).BitLen() + 7) / 8
+    d := 4*((b+3)/4) + 4
+    params := uint64(radix) | uint64(n)<<17 | uint64(len(tweak))<<49
+
+    a := num(src[:u], r)
+    bb := num(src[u:], r)
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    for k := 0; k < rounds; k++ {
+        i := k
+        if decrypt {
+            i = rounds - 1 - k
+        }
+        mod := modU
+        if i%2 == 1 {
+            mod = modV
+        }
+        if !decrypt {
+            y := c.prf(i, bb, b, d, tweak, params)
+            y.Add(y, a).Mod(y, mod)
+            a, bb = bb, y
+        } else {
+            y := c.prf(i, a, b, d, tweak, params)
+            y.Sub(bb, y).Mod(y, mod)
+            a, bb = y, a
+        }
+    }
+    str(dst[:u], a, r)
+    str(dst[u:n], bb, r)
+    return nil
+}
+
+func (c *Cipher) setTweak(round, domain, index int, word uint64) {
+    c.tweak[0] = uint64(round) | uint64(domain)<<8 | uint64(index)<<16
+    c.tweak[1] = word
+    c.cipher.SetTweak(c.tweak[:])
+}
+
+// The round function, see the package documentation.
+//
+func (c *Cipher) prf(round int, x *big.Int, b, d int, tweak []byte, params uint64) *big.Int {
+    bs := len(c.y)
+    clear(c.y)
+    var chunk [8]byte
+    for j := 0; j*8 < len(tweak); j++ {
+        clear(chunk[:])
+        copy(chunk[:], tweak[j*8:])
+        c.setTweak(round, domainTweak, j, binary.LittleEndian.Uint64(chunk[:]))
+        c.cipher.Encrypt(c.y, c.y)
+    }
+    data := make([]byte, (b+bs-1)/bs*bs)
+    x.FillBytes(data[len(data)-b:])
+    for j := 0; j*bs < len(data); j++ {
+        for i := range c.y {
+            c.y[i] ^= data[j*bs+i]
+        }
+        c.setTweak(round, domainData, j, params)
+        c.cipher.Encrypt(c.y, c.y)
+    }
+    out := append([]byte(nil), c.y...)
+    for j := 1; len(out) < d; j++ {
+        c.setTweak(round, domainOutput, j, params)
+        c.cipher.Encrypt(c.block, c.y)
+        out = append(out, c.block...)
+    }
+    return new(big.Int).SetBytes(out[:d])
+}
+
+// The number that a numeral string represents, most significant numeral
+// first.
+//
+func num(x []uint16, radix *big.Int) *big.Int {
+    n := new(big.Int)
+    d := new(big.Int)
+    for _, v := range x {
+        n.Mul(n, radix).Add(n, d.SetUint64(uint64(v)))
+    }
+    return n
+}
+
+// The numeral string of len(x) numerals that represents n.
+//
+func str(x []uint16, n, radix *big.Int) {
+    n = new(big.Int).Set(n)
+    d := new(big.Int)
+    for i := len(x) - 1; i >= 0; i-- {
+        n.DivMod(n, radix, d)
+        x[i] = uint16(d.Uint64())
+    }
+}
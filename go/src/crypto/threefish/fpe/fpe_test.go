@@ -0,0 +1,178 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package fpe
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// Frozen vectors, the key bytes are 0, 1, 2, ...
+var fpeVectors = []struct {
+	stateSize, radix          int
+	plain, tweak, ciphertext string
+}{
+	{256, 10, "4111111111111111", "", "5118301978191536"},
+	{256, 10, "0123456789", "9876543210", "2091517513"},
+	{256, 10, "000000", "tweak with more than 8 bytes", "799603"},
+	{256, 36, "thequickbrownfox", "abc", "m1avb5d1pbw07av3"},
+	{512, 10, "4111111111111111", "", "6027073378107523"},
+	{512, 10, "0123456789", "9876543210", "7797508959"},
+	{512, 10, "000000", "tweak with more than 8 bytes", "253309"},
+	{512, 36, "thequickbrownfox", "abc", "6hlz96xb1igshzc1"},
+	{1024, 10, "4111111111111111", "", "0489974812429801"},
+	{1024, 10, "0123456789", "9876543210", "8031663451"},
+	{1024, 10, "000000", "tweak with more than 8 bytes", "832989"},
+	{1024, 36, "thequickbrownfox", "abc", "poepkbi4pfomgp5c"},
+}
+
+var uint64Vectors = []struct {
+	stateSize  int
+	ciphertext uint64
+}{
+	{256, 0x15449885fe868009},
+	{512, 0xc1425215166c9ee5},
+	{1024, 0xedeabb01dd6a914f},
+}
+
+func testKey(stateSize int) []byte {
+	key := make([]byte, stateSize/8)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+func TestVectors(t *testing.T) {
+	for _, v := range fpeVectors {
+		c, err := New(testKey(v.stateSize), v.radix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, err := c.EncryptString(v.plain, []byte(v.tweak))
+		if err != nil || ct != v.ciphertext {
+			t.Errorf("FPE-%d radix %d %s: got %s, %v", v.stateSize, v.radix, v.plain, ct, err)
+		}
+		pt, err := c.DecryptString(ct, []byte(v.tweak))
+		if err != nil || pt != v.plain {
+			t.Errorf("FPE-%d radix %d %s: decryption returned %s, %v", v.stateSize, v.radix, v.plain, pt, err)
+		}
+	}
+	for _, v := range uint64Vectors {
+		c, _ := New(testKey(v.stateSize), 10)
+		x, err := c.EncryptUint64(0x0123456789abcdef, nil)
+		if err != nil || x != v.ciphertext {
+			t.Errorf("FPE-%d: EncryptUint64 returned %#x, %v", v.stateSize, x, err)
+		}
+		if x, _ = c.DecryptUint64(x, nil); x != 0x0123456789abcdef {
+			t.Errorf("FPE-%d: DecryptUint64 returned %#x", v.stateSize, x)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for _, radix := range []int{2, 3, 10, 26, 255, 256, 1000, 65535, 65536} {
+		c, err := New(testKey(512), radix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []int{2, 7, 20, 21, 64, 201} {
+			src := make([]uint16, n)
+			for i := range src {
+				src[i] = uint16(rnd.IntN(radix))
+			}
+			dst := make([]uint16, n)
+			err := c.Encrypt(dst, src, []byte("tweak"))
+			if _, small := err.(LengthError); small {
+				continue
+			}
+			if err != nil {
+				t.Fatalf("radix %d length %d: %v", radix, n, err)
+			}
+			for i, d := range dst {
+				if int(d) >= radix {
+					t.Fatalf("radix %d length %d: numeral %d out of range", radix, n, i)
+				}
+			}
+			if slices.Equal(dst, src) && n > 10 {
+				t.Errorf("radix %d length %d: no encryption", radix, n)
+			}
+			// The tweak changes the ciphertext
+			other := make([]uint16, n)
+			c.Encrypt(other, src, []byte("tweak2"))
+			if slices.Equal(dst, other) {
+				t.Errorf("radix %d length %d: tweak ignored", radix, n)
+			}
+			// Decrypt in place
+			c.Decrypt(dst, dst, []byte("tweak"))
+			if !slices.Equal(dst, src) {
+				t.Errorf("radix %d length %d: decryption failed", radix, n)
+			}
+		}
+	}
+}
+
+func TestPermutation(t *testing.T) {
+	// Encryption permutes the domain, radix 10 with six digits
+	c, _ := New(testKey(256), 10)
+	seen := make(map[string]bool)
+	for i := 0; i < 2000; i++ {
+		s := []byte("000000")
+		for j, k := len(s)-1, i; k > 0; j, k = j-1, k/10 {
+			s[j] = byte('0' + k%10)
+		}
+		ct, err := c.EncryptString(string(s), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[ct] {
+			t.Fatalf("collision for %s", s)
+		}
+		seen[ct] = true
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, radix := range []int{0, 1, 65537} {
+		if _, err := New(testKey(256), radix); err == nil {
+			t.Errorf("New accepted radix %d", radix)
+		}
+	}
+	if _, err := New(make([]byte, 20), 10); err == nil {
+		t.Error("New accepted invalid key")
+	}
+	c, _ := New(testKey(256), 10)
+	if _, err := c.EncryptString("12345", nil); err == nil {
+		t.Error("EncryptString accepted domain smaller than one million")
+	}
+	if _, err := c.EncryptString("12345a", nil); err == nil {
+		t.Error("EncryptString accepted invalid digit")
+	}
+	if err := c.Encrypt(make([]uint16, 6), []uint16{1, 2, 3, 4, 5, 10}, nil); err == nil {
+		t.Error("Encrypt accepted numeral out of range")
+	}
+	if _, err := c.EncryptString("123456", make([]byte, MaxTweakSize+1)); err == nil {
+		t.Error("EncryptString accepted long tweak")
+	}
+	c, _ = New(testKey(256), 100)
+	if _, err := c.EncryptString("123456", nil); err == nil {
+		t.Error("EncryptString accepted radix larger than 36")
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements key wrapping with Threefish.
+//
+// Wrap encrypts a key under a key-encryption key (KEK). The wrapped key
+// is deterministic and authenticated: Unwrap detects any modification.
+// The KEK length of 32, 64 or 128 bytes selects Threefish-256, -512 or
+// -1024.
+//
+// The wrapped key has the format
+//
+//    version     1 byte, 1
+//    state size  1 byte, Threefish state size / 256
+//    body        enciphered payload, at least one Threefish block
+//
+// The payload is
+//
+//    check value 12 bytes, each 0xa6
+//    key length  uint32, little endian
+//    key         the key bytes
+//    padding     zero bytes up to the Threefish block size, if the
+//                payload is shorter than one block
+//
+// The body is the payload enciphered with the wide-block mode of package
+// crypto/threefish/wide, keyed with the KEK and with the two header bytes
+// as tweak. Each body bit depends on all payload bits, thus a modified
+// body decrypts to a random payload that fails the check value test. A
+// key of up to the block size minus 16 bytes, for example a 256 bit key
+// under a Threefish-512 KEK, fits into a single Threefish block.
+//
+package keywrap
+
+import (
+    "crypto/subtle"
+    "crypto/threefish/wide"
+    "encoding/binary"
+    "strconv"
+)
+
+const (
+    version        = 1
+    headerSize     = 2
+    checkValueSize = 12
+    payloadHeader  = checkValueSize + 4
+
+    // Maximum length of a wrapped key in bytes
+    MaxKeySize = 1 << 20
+)
+
+type KEKSizeError int
+
+func (k KEKSizeError) Error() string {
+    return "crypto/threefish/keywrap: invalid key-encryption key size " + strconv.Itoa(int(k))
+}
+
+type KeySizeError int
+
+func (k KeySizeError) Error() string {
+    return "crypto/threefish/keywrap: invalid key size " + strconv.Itoa(int(k))
+}
+
+type unwrapError int
+
+func (u unwrapError) Error() string {
+    return "crypto/threefish/keywrap: key unwrap failed"
+}
+
+func newCipher(kek []byte) (*wide.Cipher, []byte, error) {
+    switch len(kek) {
+    case 32, 64, 128:
+    default:
+        return nil, nil, KEKSizeError(len(kek))
+    }
+    c, err := wide.New(kek, len(kek)*8)
+    if err != nil {
+        return nil, nil, err
+    }
+    return c, []byte{version, byte(len(kek) * 8 / 256)}, nil
+}
+
+// Wrap a key.
+//
+// kek
+//      The key-encryption key, 32, 64 or 128 bytes
+// key
+//      The key to wrap, 1 to MaxKeySize bytes
+//
+func Wrap(kek, key []byte) ([]byte, error) {
+    if len(key) == 0 || len(key) > MaxKeySize {
+        return nil, KeySizeError(len(key))
+    }
+    c, header, err := newCipher(kek)
+    if err != nil {
+        return nil, err
+    }
+    n := payloadHeader + len(key)
+    if n < c.BlockSize() {
+        n = c.BlockSize()
+    }
+    wrapped := make([]byte, headerSize+n)
+    copy(wrapped, header)
+    payload := wrapped[headerSize:]
+    for i := 0; i < checkValueSize; i++ {
+        payload[i] = 0xa6
+    }
+    binary.LittleEndian.PutUint32(payload[checkValueSize:], uint32(len(key)))
+    copy(payload[payloadHeader:], key)
+    c.Encrypt(payload, payload, header)
+    return wrapped, nil
+}
+
+// Unwrap a key.
+//
+// Unwrap returns an error if the wrapped key was modified or if the KEK
+// does not match.
+//
+// kek
+//      The key-encryption key used to wrap the key
+// wrapped
+//      The wrapped key
+//
+func Unwrap(kek, wrapped []byte) ([]byte, error) {
+    c, header, err := newCipher(kek)
+    if err != nil {
+        return nil, err
+    }
+    if len(wrapped) < headerSize+c.BlockSize() ||
+        subtle.ConstantTimeCompare(wrapped[:headerSize], header) != 1 {
+        return nil, unwrapError(0)
+    }
+    payload := make([]byte, len(wrapped)-headerSize)
+    c.Decrypt(payload, wrapped[headerSize:], header)
+
+    ok := 1
+    for i := 0; i < checkValueSize; i++ {
+        ok &= subtle.ConstantTimeByteEq(payload[i], 0xa6)
+    }
+    // The payload length must match the key length, padding only fills
+    // a single block
+    length := binary.LittleEndian.Uint32(payload[checkValueSize:])
+    expected := uint64(payloadHeader) + uint64(length)
+    if expected < uint64(c.BlockSize()) {
+        expected = uint64(c.BlockSize())
+    }
+    if ok != 1 || length == 0 || expected != uint64(len(payload)) {
+        clear(payload)
+        return nil, unwrapError(0)
+    }
+    var pad byte
+    for _, b := range payload[payloadHeader+int(length):] {
+        pad |= b
+    }
+    if pad != 0 {
+        clear(payload)
+        return nil, unwrapError(0)
+    }
+    key := append([]byte(nil), payload[payloadHeader:payloadHeader+int(length)]...)
+    clear(payload)
+    return key, nil
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package keywrap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Frozen vectors: KEK bytes 0, 1, 2, ... and key bytes 0xf0-i.
+var wrapVectors = []struct {
+	kekSize, keySize int
+	wrapped          string
+}{
+	{32, 16, "0101e74a566b5ddd44c965a84f13fba3a5b9ad772fd4a3d80266a690b44c6040be0e"},
+	{32, 32, "0101d045bd8974d27c02f7b35002144ffebe7b1142c2e563580ee8a8e9ef49b1c19083303308fcb75caeea8935cb8da7517d"},
+	{32, 64, "01019a740909edfc0b4cb95fc06289502fefb6e217a4ebb251c5f8bb3a4f2f03cf98fa224989c9f8e7437d4b62fb71690e515313c16aef98606878cbd7c935a0e04c9bdfbce75f588cfa5e4f363031dedfb1"},
+	{64, 16, "0102f92872e7813b1674aa67cc2866124fdeeb79e7363a5065e6e0e5d0571fc460c53a1a8793ecc601a854b36ba648b3337c80dc842e60236a208cd601cc74fda01a"},
+	{64, 32, "0102918c7e3c66d06702876b2b702ca3013e4847d9e11387784fa9c7f6d008fd92eec2955a9fbe9f96d791e191227cdafa1a8805a59433f9e7b3ce406ef43b2bda90"},
+	{64, 64, "0102ebc834d2bb723be1504615e8a40975c537753a17f93d1555632521984eae39d6c79ab646a612193f994b16e69391180476b644d136baa1101468dc23621231273e7dd228dffe9b27232232f4ad38580d"},
+	{128, 16, "0104c4ff2b9c7d54009c4fd0809915dea4909a2c4803ea90e7076ed2d19f0e9aed1f030c200ae0d3e782d5ecc4b698041bbd7c8fa3a34687cc2b7206f8d429d3618048895db89aa75607611ef7f2d527e12f284977cbe85a245ff9fd2de60a34f27fa68749b083ad4a0daccf39ee404471f4b6a1a727c771ac325e70c8e74cc6612b"},
+	{128, 32, "0104d0c1cb0f43641a4a1a9b642a05cd99a170a4ad7f7c17377b275d6218298369a7f33dc9add7393b2a9f014f736eb136297f9616fad6fa5d0cd3ba7f038bedba1b66f0bc044a8fedd779e3906b8581b737b97502c5c7a1bd0df084ba9bd27c090417fca0eb630dc15c935c1e14cab0f81b7ff6b00828b2053a250a139d7105947c"},
+	{128, 64, "010416a408592455aafb08ab3207b9cc98bf269fff2e370a6fc30e4e6f8d15bb76198826787424143c6be679af2924c9eaf03d7c4b2cfaed79fd0ee3d3c48a2491c70a4841fb0f6dae81942aa022449f90a7dc232624f7101358cdce7d0601c0e2722736cc48fb68dd9908c669c3f1b7a902407e6521d2c8739d3cdeb2db6ddd9454"},
+}
+
+func testKeys(kekSize, keySize int) (kek, key []byte) {
+	kek = make([]byte, kekSize)
+	for i := range kek {
+		kek[i] = byte(i)
+	}
+	key = make([]byte, keySize)
+	for i := range key {
+		key[i] = byte(0xf0 - i)
+	}
+	return
+}
+
+func TestVectors(t *testing.T) {
+	for _, v := range wrapVectors {
+		kek, key := testKeys(v.kekSize, v.keySize)
+		wrapped, err := Wrap(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(wrapped) != v.wrapped {
+			t.Errorf("KEK %d key %d: wrong wrapped key\n%x", v.kekSize, v.keySize, wrapped)
+		}
+		unwrapped, err := Unwrap(kek, wrapped)
+		if err != nil || !bytes.Equal(unwrapped, key) {
+			t.Errorf("KEK %d key %d: Unwrap failed: %v", v.kekSize, v.keySize, err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, kekSize := range []int{32, 64, 128} {
+		for _, keySize := range []int{1, 15, 16, 17, 32, 48, 49, 64, 112, 113, 128, 300} {
+			kek, key := testKeys(kekSize, keySize)
+			wrapped, err := Wrap(kek, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := keySize + 16
+			if expected < kekSize {
+				expected = kekSize
+			}
+			if len(wrapped) != 2+expected {
+				t.Errorf("KEK %d key %d: wrapped length %d", kekSize, keySize, len(wrapped))
+			}
+			unwrapped, err := Unwrap(kek, wrapped)
+			if err != nil || !bytes.Equal(unwrapped, key) {
+				t.Errorf("KEK %d key %d: Unwrap failed: %v", kekSize, keySize, err)
+			}
+			for i := range wrapped {
+				mod := append([]byte(nil), wrapped...)
+				mod[i] ^= 0x80
+				if _, err := Unwrap(kek, mod); err == nil {
+					t.Errorf("KEK %d key %d: Unwrap accepted modified byte %d", kekSize, keySize, i)
+				}
+			}
+			if _, err := Unwrap(kek, wrapped[:len(wrapped)-1]); err == nil {
+				t.Errorf("KEK %d key %d: Unwrap accepted truncated key", kekSize, keySize)
+			}
+			if _, err := Unwrap(kek, append(wrapped, 0)); err == nil {
+				t.Errorf("KEK %d key %d: Unwrap accepted extended key", kekSize, keySize)
+			}
+			kek[0] ^= 1
+			if _, err := Unwrap(kek, wrapped); err == nil {
+				t.Errorf("KEK %d key %d: Unwrap accepted wrong KEK", kekSize, keySize)
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := Wrap(make([]byte, 48), make([]byte, 32)); err == nil {
+		t.Error("Wrap accepted invalid KEK size")
+	}
+	if _, err := Wrap(make([]byte, 64), nil); err == nil {
+		t.Error("Wrap accepted empty key")
+	}
+	if _, err := Unwrap(make([]byte, 64), make([]byte, 10)); err == nil {
+		t.Error("Unwrap accepted short input")
+	}
+	// A wrapped key of another state size
+	wrapped, _ := Wrap(make([]byte, 32), make([]byte, 64))
+	if _, err := Unwrap(make([]byte, 64), wrapped); err == nil {
+		t.Error("Unwrap accepted key wrapped with another state size")
+	}
+}
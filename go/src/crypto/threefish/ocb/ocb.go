@@ -0,0 +1,289 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements the authenticated encryption mode ΘCB with the
+// Threefish tweakable block cipher.
+//
+// ΘCB, the tweakable block cipher mode underlying OCB3, encrypts and
+// authenticates in a single pass. OCB3 derives its tweaks from a block
+// cipher, Threefish provides them natively. The 128 bit Threefish tweak
+// carries the nonce, the block index and the domain:
+//
+//    tweak[0]    nonce bytes 0 - 7, little endian
+//    tweak[1]    bits 0 - 31:  nonce bytes 8 - 11, little endian
+//                bits 32 - 60: block index
+//                bits 61 - 63: domain
+//
+// Message block i (starting at 1) is encrypted with domain 0. The final
+// tag block uses the index of the last full message block and domain 1,
+// or domain 3 if the message ends with a partial block. The key stream for
+// a partial block uses domain 2. The associated data blocks use a zero
+// nonce and domain 4, a partial associated data block is padded with
+// 0x80 and zero bytes and uses domain 5.
+//
+// The block index has 29 bits, thus a message or the associated data
+// contain less than 2^29 blocks.
+//
+package ocb
+
+import (
+    "crypto/cipher"
+    "crypto/internal/aead"
+    "crypto/subtle"
+    "crypto/threefish"
+    "encoding/binary"
+    "strconv"
+    "sync"
+)
+
+const (
+    NonceSize = 12 // Size of the nonce in bytes
+    TagSize   = 16 // Default size of the authentication tag in bytes
+
+    // Minimum size of a truncated authentication tag in bytes
+    MinTagSize = 8
+)
+
+// Tweak domains
+const (
+    domainMessage = iota
+    domainFinal
+    domainPad
+    domainFinalPartial
+    domainAD
+    domainADPartial
+)
+
+const maxBlocks = 1<<29 - 1
+
+type ocb struct {
+    mu      sync.Mutex
+    cipher  *threefish.Cipher
+    tagSize int
+    tweak   [2]uint64
+    sum     []byte // checksum of the plaintext
+    auth    []byte // hash of the associated data
+    tmp     []byte
+}
+
+type TagSizeError int
+
+func (t TagSizeError) Error() string {
+    return "crypto/threefish/ocb: invalid tag size " + strconv.Itoa(int(t))
+}
+
+type authError int
+
+func (a authError) Error() string {
+    return "crypto/threefish/ocb: message authentication failed"
+}
+
+// New returns the ΘCB mode of the Threefish cipher with the default tag
+// size.
+//
+// The mode owns the cipher: it sets the cipher's tweak for each block.
+// The returned AEAD is safe for concurrent use by several goroutines.
+//
+// cipher
+//      A Threefish cipher with the key, any state size
+//
+func New(cipher *threefish.Cipher) (cipher.AEAD, error) {
+    return NewWithTagSize(cipher, TagSize)
+}
+
+// NewWithTagSize returns the ΘCB mode of the Threefish cipher with a
+// truncated or extended tag.
+//
+// cipher
+//      A Threefish cipher with the key, any state size
+// tagSize
+//      Size of the tag in bytes, at least MinTagSize and at most the
+//      Threefish block size
+//
+func NewWithTagSize(cipher *threefish.Cipher, tagSize int) (cipher.AEAD, error) {
+    bs := cipher.BlockSize()
+    if tagSize < MinTagSize || tagSize > bs {
+        return nil, TagSizeError(tagSize)
+    }
+    o := new(ocb)
+    o.cipher = cipher
+    o.tagSize = tagSize
+    o.sum = make([]byte, bs)
+    o.auth = make([]byte, bs)
+    o.tmp = make([]byte, bs)
+    return o, nil
+}
+
+func (o *ocb) NonceSize() int {
+    return NonceSize
+}
+
+func (o *ocb) Overhead() int {
+    return o.tagSize
+}
+
+// Set the tweak for a block, the nonce is nil for associated data.
+//
+func (o *ocb) setTweak(nonce []byte, index int, domain uint64) {
+    o.tweak[0] = 0
+    o.tweak[1] = uint64(index)<<32 | domain<<61
+    if nonce != nil {
+        o.tweak[0] = binary.LittleEndian.Uint64(nonce)
+        o.tweak[1] |= uint64(binary.LittleEndian.Uint32(nonce[8:]))
+    }
+    o.cipher.SetTweak(o.tweak[:])
+}
+
+// Copy a partial block into o.tmp and pad it with 0x80 and zero bytes.
+//
+func (o *ocb) padBlock(partial []byte) {
+    copy(o.tmp, partial)
+    o.tmp[len(partial)] = 0x80
+    for i := len(partial) + 1; i < len(o.tmp); i++ {
+        o.tmp[i] = 0
+    }
+}
+
+// Hash the associated data into o.auth.
+//
+func (o *ocb) hash(ad []byte) {
+    bs := len(o.auth)
+    clear(o.auth)
+    i := 1
+    for ; len(ad) >= bs; i++ {
+        o.setTweak(nil, i, domainAD)
+        o.cipher.Encrypt(o.tmp, ad)
+        subtle.XORBytes(o.auth, o.auth, o.tmp)
+        ad = ad[bs:]
+    }
+    if len(ad) > 0 {
+        o.padBlock(ad)
+        o.setTweak(nil, i, domainADPartial)
+        o.cipher.Encrypt(o.tmp, o.tmp)
+        subtle.XORBytes(o.auth, o.auth, o.tmp)
+    }
+}
+
+// Compute the tag from the checksum and the associated data hash, the
+// tag is in o.tmp.
+//
+func (o *ocb) tag(nonce []byte, index int, partial bool) {
+    domain := uint64(domainFinal)
+    if partial {
+        domain = domainFinalPartial
+    }
+    o.setTweak(nonce, index, domain)
+    o.cipher.Encrypt(o.tmp, o.sum)
+    subtle.XORBytes(o.tmp, o.tmp, o.auth)
+}
+
+// Compute the key stream for a partial block into o.tmp.
+//
+func (o *ocb) pad(nonce []byte, index int) {
+    clear(o.tmp)
+    o.setTweak(nonce, index, domainPad)
+    o.cipher.Encrypt(o.tmp, o.tmp)
+}
+
+func (o *ocb) checkNonce(nonce []byte) {
+    if len(nonce) != NonceSize {
+        panic("crypto/threefish/ocb: incorrect nonce length given to ΘCB")
+    }
+}
+
+// Check if the text or the associated data exceed the block index.
+//
+func (o *ocb) tooLarge(text, ad []byte) bool {
+    bs := len(o.sum)
+    return len(text)/bs >= maxBlocks || len(ad)/bs >= maxBlocks
+}
+
+func (o *ocb) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+    o.checkNonce(nonce)
+    if o.tooLarge(plaintext, additionalData) {
+        panic("crypto/threefish/ocb: message too large for ΘCB")
+    }
+    ret, out := aead.SliceForAppend(dst, len(plaintext)+o.tagSize)
+
+    o.mu.Lock()
+    defer o.mu.Unlock()
+
+    bs := len(o.sum)
+    o.hash(additionalData)
+    clear(o.sum)
+    i := 0
+    for len(plaintext) >= bs {
+        i++
+        subtle.XORBytes(o.sum, o.sum, plaintext[:bs])
+        o.setTweak(nonce, i, domainMessage)
+        o.cipher.Encrypt(out, plaintext)
+        out = out[bs:]
+        plaintext = plaintext[bs:]
+    }
+    partial := len(plaintext) > 0
+    if partial {
+        o.padBlock(plaintext)
+        subtle.XORBytes(o.sum, o.sum, o.tmp)
+        o.pad(nonce, i)
+        subtle.XORBytes(out, plaintext, o.tmp)
+        out = out[len(plaintext):]
+    }
+    o.tag(nonce, i, partial)
+    copy(out, o.tmp[:o.tagSize])
+    return ret
+}
+
+func (o *ocb) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+    o.checkNonce(nonce)
+    if len(ciphertext) < o.tagSize || o.tooLarge(ciphertext, additionalData) {
+        return nil, authError(0)
+    }
+    tag := ciphertext[len(ciphertext)-o.tagSize:]
+    ciphertext = ciphertext[:len(ciphertext)-o.tagSize]
+    ret, out := aead.SliceForAppend(dst, len(ciphertext))
+
+    o.mu.Lock()
+    defer o.mu.Unlock()
+
+    bs := len(o.sum)
+    o.hash(additionalData)
+    clear(o.sum)
+    plain := out
+    i := 0
+    for len(ciphertext) >= bs {
+        i++
+        o.setTweak(nonce, i, domainMessage)
+        o.cipher.Decrypt(plain, ciphertext)
+        subtle.XORBytes(o.sum, o.sum, plain[:bs])
+        plain = plain[bs:]
+        ciphertext = ciphertext[bs:]
+    }
+    partial := len(ciphertext) > 0
+    if partial {
+        o.pad(nonce, i)
+        subtle.XORBytes(plain, ciphertext, o.tmp)
+        o.padBlock(plain[:len(ciphertext)])
+        subtle.XORBytes(o.sum, o.sum, o.tmp)
+    }
+    o.tag(nonce, i, partial)
+    if subtle.ConstantTimeCompare(o.tmp[:o.tagSize], tag) != 1 {
+        clear(out)
+        return nil, authError(0)
+    }
+    return ret, nil
+}
@@ -0,0 +1,205 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package ocb
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/threefish"
+	"encoding/binary"
+	"testing"
+)
+
+func testCipher(stateSize int) *threefish.Cipher {
+	key := make([]byte, stateSize/8)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	c, _ := threefish.New(key, nil)
+	return c
+}
+
+var testNonce = []byte{0xa0, 0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab}
+
+// Encrypt one block with the tweak of the package doc, nonce nil for
+// the associated data.
+func refBlock(c *threefish.Cipher, nonce []byte, index int, domain uint64, in []byte) []byte {
+	var t0, t1 uint64
+	if nonce != nil {
+		t0 = binary.LittleEndian.Uint64(nonce)
+		t1 = uint64(binary.LittleEndian.Uint32(nonce[8:]))
+	}
+	c.SetTweak([]uint64{t0, t1 | uint64(index)<<32 | domain<<61})
+	out := make([]byte, c.BlockSize())
+	c.Encrypt(out, in)
+	return out
+}
+
+func refPad(partial []byte, bs int) []byte {
+	b := make([]byte, bs)
+	copy(b, partial)
+	b[len(partial)] = 0x80
+	return b
+}
+
+// Seal a message as described in the package doc.
+func refSeal(c *threefish.Cipher, nonce, pt, ad []byte, tagSize int) []byte {
+	bs := c.BlockSize()
+	auth := make([]byte, bs)
+	i := 1
+	for ; len(ad) >= bs; i++ {
+		subtle.XORBytes(auth, auth, refBlock(c, nil, i, 4, ad[:bs]))
+		ad = ad[bs:]
+	}
+	if len(ad) > 0 {
+		subtle.XORBytes(auth, auth, refBlock(c, nil, i, 5, refPad(ad, bs)))
+	}
+	var sealed []byte
+	sum := make([]byte, bs)
+	i = 0
+	for ; len(pt) >= bs; pt = pt[bs:] {
+		i++
+		subtle.XORBytes(sum, sum, pt[:bs])
+		sealed = append(sealed, refBlock(c, nonce, i, 0, pt[:bs])...)
+	}
+	domain := uint64(1)
+	if len(pt) > 0 {
+		subtle.XORBytes(sum, sum, refPad(pt, bs))
+		stream := refBlock(c, nonce, i, 2, make([]byte, bs))
+		subtle.XORBytes(stream, stream[:len(pt)], pt)
+		sealed = append(sealed, stream[:len(pt)]...)
+		domain = 3
+	}
+	tag := refBlock(c, nonce, i, domain, sum)
+	subtle.XORBytes(tag, tag, auth)
+	return append(sealed, tag[:tagSize]...)
+}
+
+func TestReference(t *testing.T) {
+	data := make([]byte, 300)
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	for _, size := range []int{256, 512, 1024} {
+		bs := size / 8
+		a, _ := New(testCipher(size))
+		// Empty, partial and full final blocks of the message and the
+		// associated data
+		for _, n := range []int{0, 1, bs - 1, bs, bs + 1, 2*bs + 5} {
+			for _, m := range []int{0, 3, bs, bs + 7} {
+				pt, ad := data[:n], data[len(data)-m:]
+				sealed := a.Seal(nil, testNonce, pt, ad)
+				if expected := refSeal(testCipher(size), testNonce, pt, ad, TagSize); !bytes.Equal(sealed, expected) {
+					t.Errorf("Threefish-%d %d/%d: got %x, expected %x", size, n, m, sealed, expected)
+				}
+				opened, err := a.Open(nil, testNonce, sealed, ad)
+				if err != nil || !bytes.Equal(opened, pt) {
+					t.Errorf("Threefish-%d %d/%d: Open failed: %v", size, n, m, err)
+				}
+			}
+		}
+	}
+}
+
+func TestChecksumPadding(t *testing.T) {
+	// A full block equal to a padded partial block gives the same
+	// checksum, the tag domain must still separate the messages
+	for _, size := range []int{256, 512, 1024} {
+		bs := size / 8
+		a, _ := New(testCipher(size))
+		partial := []byte("partial")
+		full := refPad(partial, bs)
+		sealedPartial := a.Seal(nil, testNonce, partial, nil)
+		sealedFull := a.Seal(nil, testNonce, full, nil)
+		if bytes.Equal(sealedPartial[len(partial):], sealedFull[bs:]) {
+			t.Errorf("Threefish-%d: equal tags for a full and a padded partial block", size)
+		}
+		// The partial block is a key stream, not a block encryption
+		stream := refBlock(testCipher(size), testNonce, 0, domainPad, make([]byte, bs))
+		subtle.XORBytes(stream, stream[:len(partial)], partial)
+		if !bytes.Equal(sealedPartial[:len(partial)], stream[:len(partial)]) {
+			t.Errorf("Threefish-%d: wrong partial block", size)
+		}
+		// A partial block that decrypts to a different length
+		forged := append(append([]byte(nil), sealedPartial[:len(partial)-1]...), sealedPartial[len(partial):]...)
+		if _, err := a.Open(nil, testNonce, forged, nil); err == nil {
+			t.Errorf("Threefish-%d: Open accepted a shortened partial block", size)
+		}
+	}
+}
+
+func TestTagSize(t *testing.T) {
+	pt := []byte("truncated and extended tags")
+	for _, size := range []int{256, 512, 1024} {
+		long, _ := NewWithTagSize(testCipher(size), size/8)
+		full := long.Seal(nil, testNonce, pt, nil)
+		for _, tagSize := range []int{MinTagSize, TagSize, size / 8} {
+			a, err := NewWithTagSize(testCipher(size), tagSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Overhead() != tagSize || a.NonceSize() != NonceSize {
+				t.Errorf("Threefish-%d: wrong sizes", size)
+			}
+			// A truncated tag is a prefix of the full tag
+			sealed := a.Seal(nil, testNonce, pt, nil)
+			if !bytes.Equal(sealed, full[:len(pt)+tagSize]) {
+				t.Errorf("Threefish-%d %d: tag is not a prefix of the full tag", size, tagSize)
+			}
+			if _, err := a.Open(nil, testNonce, sealed[:len(sealed)-1], nil); err == nil {
+				t.Errorf("Threefish-%d %d: Open accepted a shorter tag", size, tagSize)
+			}
+			if _, err := a.Open(nil, testNonce, sealed[:tagSize-1], nil); err == nil {
+				t.Errorf("Threefish-%d %d: Open accepted a message shorter than the tag", size, tagSize)
+			}
+		}
+	}
+	if _, err := NewWithTagSize(testCipher(256), 33); err == nil {
+		t.Error("NewWithTagSize accepted tag larger than block")
+	}
+	if _, err := NewWithTagSize(testCipher(256), MinTagSize-1); err == nil {
+		t.Error("NewWithTagSize accepted short tag")
+	}
+}
+
+func TestInPlace(t *testing.T) {
+	a, _ := New(testCipher(512))
+	pt := bytes.Repeat([]byte("in place "), 20)
+	ad := []byte("header")
+	expected := a.Seal(nil, testNonce, pt, ad)
+	buf := append([]byte(nil), pt...)
+	buf = a.Seal(buf[:0], testNonce, buf, ad)
+	if !bytes.Equal(buf, expected) {
+		t.Error("in place Seal differs")
+	}
+	if _, err := a.Open(buf[:0], testNonce, buf, ad); err != nil || !bytes.Equal(buf[:len(pt)], pt) {
+		t.Error("in place Open failed")
+	}
+	for i := range expected {
+		mod := append([]byte(nil), expected...)
+		mod[i] ^= 0x10
+		if _, err := a.Open(nil, testNonce, mod, ad); err == nil {
+			t.Errorf("Open accepted modified byte %d", i)
+		}
+	}
+	nonce := append([]byte(nil), testNonce...)
+	nonce[11] ^= 1
+	if _, err := a.Open(nil, nonce, expected, ad); err == nil {
+		t.Error("Open accepted modified nonce")
+	}
+}
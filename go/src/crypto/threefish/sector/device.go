@@ -0,0 +1,221 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package sector
+
+import (
+    "io"
+    "sync"
+)
+
+// A Device provides the plaintext view of an encrypted image.
+//
+// Device implements io.ReaderAt and io.WriterAt. Sector n of the image
+// starts at offset n * sector size, the plaintext and the image have the
+// same size. A write that does not cover complete sectors reads, decrypts
+// and re-encrypts the touched sectors.
+//
+// Because of ciphertext stealing the image size modulo the sector size
+// must be zero or at least the Threefish block size. WriteAt rejects
+// writes that would create a shorter last sector.
+//
+// All functions are safe for concurrent use by several goroutines.
+//
+type Device struct {
+    mu     sync.RWMutex
+    cipher *Cipher
+    r      io.ReaderAt
+    w      io.WriterAt
+    size   int64
+}
+
+// NewDevice creates and returns a Device.
+//
+// cipher
+//      The sector cipher
+// r
+//      Reads the encrypted image
+// w
+//      Writes the encrypted image, nil for a read only device
+// size
+//      The current size of the encrypted image in bytes, for example
+//      the size of the image file
+//
+func NewDevice(cipher *Cipher, r io.ReaderAt, w io.WriterAt, size int64) *Device {
+    return &Device{cipher: cipher, r: r, w: w, size: size}
+}
+
+// Size returns the current size of the image in bytes.
+//
+func (d *Device) Size() int64 {
+    d.mu.RLock()
+    defer d.mu.RUnlock()
+    return d.size
+}
+
+// Length of the sector that starts at base, zero if the sector is
+// beyond the end of the image.
+//
+func (d *Device) sectorLength(base int64) int {
+    ss := int64(d.cipher.sectorSize)
+    switch {
+    case base >= d.size:
+        return 0
+    case d.size-base < ss:
+        return int(d.size - base)
+    }
+    return int(ss)
+}
+
+// Read and decrypt the sector that starts at base into buf.
+//
+func (d *Device) readSector(buf []byte, base int64) (int, error) {
+    m := d.sectorLength(base)
+    if m == 0 {
+        return 0, nil
+    }
+    if m < len(d.cipher.block) {
+        return 0, partialSectorError(base)
+    }
+    n, err := d.r.ReadAt(buf[:m], base)
+    if n < m {
+        if err == nil || err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        return 0, err
+    }
+    d.cipher.DecryptSector(buf, buf[:m], uint64(base/int64(d.cipher.sectorSize)))
+    return m, nil
+}
+
+// ReadAt reads plaintext of the image starting at offset off, see
+// io.ReaderAt.
+//
+func (d *Device) ReadAt(p []byte, off int64) (int, error) {
+    if off < 0 {
+        return 0, offsetError(off)
+    }
+    d.mu.RLock()
+    defer d.mu.RUnlock()
+
+    ss := int64(d.cipher.sectorSize)
+    buf := make([]byte, ss)
+    n := 0
+    for n < len(p) {
+        if off >= d.size {
+            return n, io.EOF
+        }
+        base := off - off%ss
+        m, err := d.readSector(buf, base)
+        if err != nil {
+            return n, err
+        }
+        c := copy(p[n:], buf[off-base:m])
+        n += c
+        off += int64(c)
+    }
+    return n, nil
+}
+
+// WriteAt writes plaintext to the image starting at offset off, see
+// io.WriterAt.
+//
+// A write beyond the end of the image fills the gap with encrypted zero
+// bytes.
+//
+func (d *Device) WriteAt(p []byte, off int64) (int, error) {
+    if off < 0 {
+        return 0, offsetError(off)
+    }
+    if d.w == nil {
+        return 0, readOnlyError(0)
+    }
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    ss := int64(d.cipher.sectorSize)
+    end := off + int64(len(p))
+    if end > d.size {
+        if tail := end % ss; tail != 0 && tail < int64(len(d.cipher.block)) {
+            return 0, partialSectorError(end - tail)
+        }
+    }
+    buf := make([]byte, ss)
+    if off > d.size {
+        zero := make([]byte, ss)
+        for d.size < off {
+            gap := ss - d.size%ss
+            if gap > off-d.size {
+                gap = off - d.size
+            }
+            if err := d.write(buf, zero[:gap], d.size); err != nil {
+                return 0, err
+            }
+        }
+    }
+    if err := d.write(buf, p, off); err != nil {
+        return 0, err
+    }
+    return len(p), nil
+}
+
+// Write plaintext at an offset that is not beyond the end of the image.
+//
+// If the write ends in a new last sector shorter than one block the
+// function pads the sector with zero bytes. This happens only while
+// WriteAt fills a gap, the following write then replaces the padding.
+//
+func (d *Device) write(buf, p []byte, off int64) error {
+    ss := int64(d.cipher.sectorSize)
+    for len(p) > 0 {
+        base := off - off%ss
+        inner := int(off - base)
+        c := len(buf) - inner
+        if c > len(p) {
+            c = len(p)
+        }
+        m := d.sectorLength(base)
+        if inner > 0 || inner+c < m {
+            // Partial update, merge with the existing plaintext
+            var err error
+            if m, err = d.readSector(buf, base); err != nil {
+                return err
+            }
+        }
+        copy(buf[inner:], p[:c])
+        length := inner + c
+        if length < m {
+            length = m
+        }
+        if length < len(d.cipher.block) {
+            length = len(d.cipher.block)
+            for i := inner + c; i < length; i++ {
+                buf[i] = 0
+            }
+        }
+        d.cipher.EncryptSector(buf, buf[:length], uint64(base/ss))
+        if _, err := d.w.WriteAt(buf[:length], base); err != nil {
+            return err
+        }
+        if base+int64(length) > d.size {
+            d.size = base + int64(length)
+        }
+        p = p[c:]
+        off += int64(c)
+    }
+    return nil
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements sector encryption for disk and volume images
+// with Threefish.
+//
+// The mode is similar to XTS but uses the native Threefish tweak: block j
+// of sector s is encrypted with the tweak words {s, j}. Thus the mode needs
+// no second key and no encryption of the sector number. If a sector is not
+// a multiple of the block size the mode uses ciphertext stealing as XTS
+// does, the ciphertext has the same length as the plaintext.
+//
+// A Device wraps an io.ReaderAt and io.WriterAt, for example an os.File,
+// and provides transparent access to the plaintext of an encrypted image.
+//
+// NOTE: like XTS the mode does not authenticate the data and encrypts
+// equal plaintext in the same sector to equal ciphertext.
+//
+package sector
+
+import (
+    "crypto/threefish"
+    "strconv"
+    "sync"
+)
+
+// A Cipher encrypts and decrypts sectors of a fixed size.
+//
+// All functions are safe for concurrent use by several goroutines.
+//
+type Cipher struct {
+    mu         sync.Mutex
+    cipher     *threefish.Cipher
+    sectorSize int
+    tweak      [2]uint64
+    block      []byte // ciphertext stealing buffers
+    tail       []byte
+}
+
+type SectorSizeError int
+
+func (s SectorSizeError) Error() string {
+    return "crypto/threefish/sector: invalid sector size " + strconv.Itoa(int(s))
+}
+
+type partialSectorError int64
+
+func (p partialSectorError) Error() string {
+    return "crypto/threefish/sector: sector at offset " + strconv.FormatInt(int64(p), 10) +
+        " shorter than one block"
+}
+
+type readOnlyError int
+
+func (r readOnlyError) Error() string {
+    return "crypto/threefish/sector: device is read only"
+}
+
+type offsetError int64
+
+func (o offsetError) Error() string {
+    return "crypto/threefish/sector: invalid offset " + strconv.FormatInt(int64(o), 10)
+}
+
+// New creates and returns a sector Cipher.
+//
+// key
+//      Key data, the key length of 32, 64 or 128 bytes selects the
+//      Threefish state size
+// sectorSize
+//      Size of a sector in bytes, at least the Threefish block size
+//
+func New(key []byte, sectorSize int) (*Cipher, error) {
+    c, err := threefish.New(key, nil)
+    if err != nil {
+        return nil, err
+    }
+    if sectorSize < c.BlockSize() {
+        return nil, SectorSizeError(sectorSize)
+    }
+    bs := c.BlockSize()
+    return &Cipher{cipher: c, sectorSize: sectorSize, block: make([]byte, bs), tail: make([]byte, bs)}, nil
+}
+
+// SectorSize returns the sector size in bytes.
+//
+func (c *Cipher) SectorSize() int {
+    return c.sectorSize
+}
+
+// Check the data length of a sector operation.
+//
+func (c *Cipher) check(dst, src []byte) {
+    if len(src) < len(c.block) || len(src) > c.sectorSize {
+        panic("crypto/threefish/sector: invalid sector data length")
+    }
+    if len(dst) < len(src) {
+        panic("crypto/threefish/sector: output smaller than input")
+    }
+}
+
+func (c *Cipher) setTweak(sector uint64, block int) {
+    c.tweak[0] = sector
+    c.tweak[1] = uint64(block)
+    c.cipher.SetTweak(c.tweak[:])
+}
+
+// Encrypt a sector.
+// Dst and src may point at the same memory.
+//
+// Src may be shorter than the sector size, for example the last sector
+// of an image, but must contain at least one block.
+//
+// dst
+//      Destination of encrypted data (cipher data)
+// src
+//      Contains the plain data of the sector
+// sector
+//      The sector number
+//
+func (c *Cipher) EncryptSector(dst, src []byte, sector uint64) {
+    c.check(dst, src)
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    bs := len(c.block)
+    full := len(src) / bs
+    rest := len(src) % bs
+    if rest != 0 {
+        full--
+    }
+    for j := 0; j < full; j++ {
+        c.setTweak(sector, j)
+        c.cipher.Encrypt(dst[j*bs:], src[j*bs:])
+    }
+    if rest == 0 {
+        return
+    }
+    // Ciphertext stealing: the head of the last full block's ciphertext
+    // becomes the partial ciphertext block, its remaining bytes pad the
+    // partial plaintext block.
+    last := full * bs
+    c.setTweak(sector, full)
+    c.cipher.Encrypt(c.block, src[last:])
+    copy(c.tail, c.block[:rest])
+    copy(c.block, src[last+bs:len(src)])
+    copy(dst[last+bs:len(src)], c.tail[:rest])
+    c.setTweak(sector, full+1)
+    c.cipher.Encrypt(dst[last:], c.block)
+}
+
+// Decrypt a sector.
+// Dst and src may point at the same memory.
+//
+// dst
+//      Destination of decrypted data (plain data)
+// src
+//      Contains the encrypted data of the sector
+// sector
+//      The sector number
+//
+func (c *Cipher) DecryptSector(dst, src []byte, sector uint64) {
+    c.check(dst, src)
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    bs := len(c.block)
+    full := len(src) / bs
+    rest := len(src) % bs
+    if rest != 0 {
+        full--
+    }
+    for j := 0; j < full; j++ {
+        c.setTweak(sector, j)
+        c.cipher.Decrypt(dst[j*bs:], src[j*bs:])
+    }
+    if rest == 0 {
+        return
+    }
+    last := full * bs
+    c.setTweak(sector, full+1)
+    c.cipher.Decrypt(c.block, src[last:])
+    // c.block holds the partial plaintext and the stolen ciphertext bytes
+    copy(c.tail, c.block[:rest])
+    copy(c.block, src[last+bs:len(src)])
+    c.setTweak(sector, full)
+    c.cipher.Decrypt(dst[last:], c.block)
+    copy(dst[last+bs:len(src)], c.tail[:rest])
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package sector
+
+import (
+	"bytes"
+	"crypto/threefish"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+func testKey(size int) []byte {
+	k := make([]byte, size/8)
+	for i := range k {
+		k[i] = byte(i * 7)
+	}
+	return k
+}
+
+// An in-memory image, grows on write like a file.
+type memImage struct {
+	data []byte
+}
+
+func (m *memImage) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memImage) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(m.data) {
+		m.data = append(m.data, make([]byte, end-len(m.data))...)
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func TestSectorTweak(t *testing.T) {
+	// Full blocks are Threefish with the tweak {sector, block}
+	for _, size := range []int{256, 512, 1024} {
+		c, err := New(testKey(size), 512)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, _ := threefish.New(testKey(size), nil)
+		bs := ref.BlockSize()
+		src := make([]byte, 512)
+		for i := range src {
+			src[i] = byte(i)
+		}
+		dst := make([]byte, 512)
+		c.EncryptSector(dst, src, 42)
+		block := make([]byte, bs)
+		for j := 0; j < 512/bs; j++ {
+			ref.SetTweak([]uint64{42, uint64(j)})
+			ref.Encrypt(block, src[j*bs:])
+			if !bytes.Equal(block, dst[j*bs:(j+1)*bs]) {
+				t.Errorf("Threefish-%d: wrong ciphertext of block %d", size, j)
+			}
+		}
+	}
+}
+
+func TestSectorRoundTrip(t *testing.T) {
+	for _, size := range []int{256, 512, 1024} {
+		c, _ := New(testKey(size), 4096)
+		bs := size / 8
+		for _, n := range []int{bs, bs + 1, 2*bs - 1, 2 * bs, 3*bs + 5, 4000, 4096} {
+			src := make([]byte, n)
+			for i := range src {
+				src[i] = byte(i * 3)
+			}
+			ct := make([]byte, n)
+			c.EncryptSector(ct, src, 7)
+			if bytes.Equal(ct, src) {
+				t.Errorf("Threefish-%d length %d: no encryption", size, n)
+			}
+			// The ciphertext depends on the sector number
+			other := make([]byte, n)
+			c.EncryptSector(other, src, 8)
+			if bytes.Equal(ct, other) {
+				t.Errorf("Threefish-%d length %d: sector number ignored", size, n)
+			}
+			// Decrypt in place
+			c.DecryptSector(ct, ct, 7)
+			if !bytes.Equal(ct, src) {
+				t.Errorf("Threefish-%d length %d: decryption failed", size, n)
+			}
+			// Ciphertext stealing: a change of the partial block affects
+			// the last full block
+			if n%bs != 0 {
+				mod := append([]byte(nil), src...)
+				mod[n-1] ^= 1
+				c.EncryptSector(ct, src, 7)
+				c.EncryptSector(other, mod, 7)
+				last := (n/bs - 1) * bs
+				if bytes.Equal(ct[last:last+bs], other[last:last+bs]) {
+					t.Errorf("Threefish-%d length %d: tail not stolen", size, n)
+				}
+			}
+		}
+	}
+}
+
+func TestSectorSize(t *testing.T) {
+	if _, err := New(testKey(512), 63); err == nil {
+		t.Error("New accepted sector smaller than block")
+	}
+	if _, err := New(make([]byte, 20), 512); err == nil {
+		t.Error("New accepted invalid key")
+	}
+}
+
+func TestDevice(t *testing.T) {
+	c, _ := New(testKey(512), 256)
+	img := new(memImage)
+	dev := NewDevice(c, img, img, 0)
+	plain := make([]byte, 0, 5000)
+
+	rnd := rand.New(rand.NewPCG(1, 2))
+	write := func(off, n int) {
+		p := make([]byte, n)
+		for i := range p {
+			p[i] = byte(rnd.Uint32())
+		}
+		if w, err := dev.WriteAt(p, int64(off)); w != n || err != nil {
+			t.Fatalf("WriteAt(%d, %d) returned %d, %v", off, n, w, err)
+		}
+		if off+n > len(plain) {
+			plain = append(plain, make([]byte, off+n-len(plain))...)
+		}
+		copy(plain[off:], p)
+	}
+	write(0, 100)
+	write(50, 300)   // extends a partial sector
+	write(1000, 100) // gap with zero bytes
+	write(255, 2)    // crosses a sector boundary
+	write(1100, 1012)
+	write(2314, 100) // gap ends 10 bytes into a sector
+	for i := 0; i < 50; i++ {
+		off := rnd.IntN(len(plain) - 400)
+		write(off, 1+rnd.IntN(400))
+	}
+	if dev.Size() != int64(len(plain)) || len(img.data) != len(plain) {
+		t.Fatalf("size %d, image %d, expected %d", dev.Size(), len(img.data), len(plain))
+	}
+	if bytes.Equal(img.data[:256], plain[:256]) {
+		t.Error("image not encrypted")
+	}
+	got := make([]byte, len(plain))
+	if n, err := dev.ReadAt(got, 0); n != len(plain) || err != nil {
+		t.Fatalf("ReadAt returned %d, %v", n, err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("wrong plaintext")
+	}
+
+	// A new device on the same image, read past the end
+	dev = NewDevice(c, img, nil, int64(len(img.data)))
+	part := make([]byte, 300)
+	n, err := dev.ReadAt(part, int64(len(plain)-100))
+	if n != 100 || err != io.EOF || !bytes.Equal(part[:n], plain[len(plain)-100:]) {
+		t.Errorf("ReadAt at end returned %d, %v", n, err)
+	}
+	if _, err := dev.WriteAt(part, 0); err == nil {
+		t.Error("read only device accepted write")
+	}
+}
+
+func TestDeviceShortSector(t *testing.T) {
+	c, _ := New(testKey(512), 256)
+	img := new(memImage)
+	dev := NewDevice(c, img, img, 0)
+	if _, err := dev.WriteAt(make([]byte, 10), 0); err == nil {
+		t.Error("WriteAt created sector shorter than one block")
+	}
+	if _, err := dev.WriteAt(make([]byte, 10), 256); err == nil {
+		t.Error("WriteAt created sector shorter than one block")
+	}
+	if _, err := dev.WriteAt(make([]byte, 10), -1); err == nil {
+		t.Error("WriteAt accepted negative offset")
+	}
+}
@@ -20,6 +20,9 @@
 // specification. The Skein digest algorithm uses Threefish to generate
 // the digests.
 //
+// NewCTR provides a counter mode that uses the Threefish tweak as block
+// counter.
+//
 // NOTE: Threefish is a new cipher algorithm  - use with care until fully analysed.
 //
 package threefish
@@ -0,0 +1,196 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package threefish
+
+import (
+    "crypto/subtle"
+    "encoding/binary"
+    "io"
+    "strconv"
+)
+
+// Size of the counter mode nonce in bytes
+const CTRNonceSize = 8
+
+// A CTR is Threefish in counter mode.
+//
+// Threefish is a tweakable cipher, thus the counter mode uses the tweak
+// instead of the plaintext block to make each key stream block unique:
+// key stream block i is the encryption of an all zero block with the
+// tweak words {i, nonce}. The nonce is read as little endian uint64.
+//
+// CTR implements crypto/cipher.Stream and io.Seeker. An application
+// may seek to any block offset, the counter covers 2^64 blocks. The
+// counter does not wrap, XORKeyStream panics at the end of the key
+// stream.
+//
+// NOTE: never use the same key and nonce combination for two messages.
+//
+type CTR struct {
+    cipher  *Cipher
+    tweak   [2]uint64
+    zero    []uint64
+    words   []uint64
+    block   []byte // key stream block of counter
+    counter uint64 // block number of the current position
+    pos     int    // byte offset inside the current block
+    cached  bool   // true if block contains the key stream of counter
+    end     bool   // true if the key stream of all 2^64 blocks is used
+}
+
+type NonceSizeError int
+
+func (n NonceSizeError) Error() string {
+    return "crypto/threefish: invalid nonce size " + strconv.Itoa(int(n))
+}
+
+type ctrOffsetError int64
+
+func (c ctrOffsetError) Error() string {
+    return "crypto/threefish: invalid counter mode offset " + strconv.FormatInt(int64(c), 10)
+}
+
+type ctrWhenceError int
+
+func (c ctrWhenceError) Error() string {
+    return "crypto/threefish: invalid counter mode seek whence " + strconv.Itoa(int(c))
+}
+
+// NewCTR creates and returns a Threefish counter mode stream.
+//
+// The CTR owns the cipher: it sets the cipher's tweak for each key
+// stream block, thus an application must not use the cipher for other
+// purposes while it uses the CTR.
+//
+// cipher
+//      A Threefish cipher with the key, any state size
+// nonce
+//      The nonce, CTRNonceSize bytes
+//
+func NewCTR(cipher *Cipher, nonce []byte) (*CTR, error) {
+    if len(nonce) != CTRNonceSize {
+        return nil, NonceSizeError(len(nonce))
+    }
+    words := cipher.BlockSize() / 8
+    c := new(CTR)
+    c.cipher = cipher
+    c.tweak[1] = binary.LittleEndian.Uint64(nonce)
+    c.zero = make([]uint64, words)
+    c.words = make([]uint64, words)
+    c.block = make([]byte, words*8)
+    return c, nil
+}
+
+// Compute the key stream block of the current counter.
+//
+func (c *CTR) keyStream() {
+    c.tweak[0] = c.counter
+    c.cipher.SetTweak(c.tweak[:])
+    c.cipher.Encrypt64(c.words, c.zero)
+    for i, w := range c.words {
+        binary.LittleEndian.PutUint64(c.block[i*8:], w)
+    }
+    c.cached = true
+}
+
+// XORKeyStream XORs each byte in the given slice with a byte from the
+// key stream. Dst and src may point at the same memory.
+//
+// Subsequent calls continue the key stream. XORKeyStream panics if src
+// reaches beyond block 2^64 - 1, before it changes dst.
+//
+func (c *CTR) XORKeyStream(dst, src []byte) {
+    if len(dst) < len(src) {
+        panic("crypto/threefish: output smaller than input")
+    }
+    if !c.Available(len(src)) {
+        panic("crypto/threefish: counter wrapped")
+    }
+    for len(src) > 0 {
+        if !c.cached {
+            c.keyStream()
+        }
+        n := subtle.XORBytes(dst, src, c.block[c.pos:])
+        dst = dst[n:]
+        src = src[n:]
+        c.pos += n
+        if c.pos == len(c.block) {
+            if c.counter == ^uint64(0) {
+                c.end = true
+                return
+            }
+            c.counter++
+            c.pos = 0
+            c.cached = false
+        }
+    }
+}
+
+// Available reports if the key stream holds n more bytes at the current
+// position. XORKeyStream panics for a longer input, thus an application
+// that takes the block number of SeekBlock from untrusted data checks
+// the input length with Available first.
+//
+func (c *CTR) Available(n int) bool {
+    if n <= 0 {
+        return n == 0
+    }
+    return !c.end && uint64(c.pos+n-1)/uint64(len(c.block)) <= ^uint64(0)-c.counter
+}
+
+// SeekBlock sets the key stream position to the start of block number
+// block. SeekBlock reaches all 2^64 blocks, also those beyond the range
+// of an int64 byte offset. The key stream ends after block 2^64 - 1,
+// see Available.
+//
+func (c *CTR) SeekBlock(block uint64) {
+    if c.counter != block {
+        c.cached = false
+    }
+    c.counter = block
+    c.pos = 0
+    c.end = false
+}
+
+// Seek sets the key stream position for the next XORKeyStream call,
+// see io.Seeker. The offset counts bytes. The key stream has no defined
+// end, thus Seek does not support io.SeekEnd.
+//
+func (c *CTR) Seek(offset int64, whence int) (int64, error) {
+    blockBytes := uint64(len(c.block))
+    switch whence {
+    case io.SeekStart:
+    case io.SeekCurrent:
+        current := c.counter*blockBytes + uint64(c.pos)
+        if c.end || c.counter > uint64(1<<63-1)/blockBytes || current > 1<<63-1 {
+            return 0, ctrOffsetError(-1)
+        }
+        if offset > 1<<63-1-int64(current) {
+            return 0, ctrOffsetError(offset)
+        }
+        offset += int64(current)
+    default:
+        return 0, ctrWhenceError(whence)
+    }
+    if offset < 0 {
+        return 0, ctrOffsetError(offset)
+    }
+    c.SeekBlock(uint64(offset) / blockBytes)
+    c.pos = int(uint64(offset) % blockBytes)
+    return offset, nil
+}
@@ -21,6 +21,7 @@ import (
     "encoding/binary"
     "encoding/hex"
     "fmt"
+    "io"
     "testing"
     "bytes"
 )
@@ -312,3 +313,134 @@ func basicTest1024() bool {
 
     return true
 }
+
+func TestCTR(t *testing.T) {
+    nonce := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+    for _, size := range []int{256, 512, 1024} {
+        k := make([]byte, size/8)
+        for i := range k {
+            k[i] = byte(i)
+        }
+        // Reference key stream: encrypt zero blocks, tweak is {block, nonce}
+        ref, _ := New(k, nil)
+        bs := ref.BlockSize()
+        stream := make([]byte, 5*bs)
+        zero := make([]byte, bs)
+        for i := 0; i < 5; i++ {
+            ref.SetTweak([]uint64{uint64(i), binary.LittleEndian.Uint64(nonce)})
+            ref.Encrypt(stream[i*bs:], zero)
+        }
+
+        c, _ := New(k, nil)
+        ctr, err := NewCTR(c, nonce)
+        if err != nil {
+            t.Fatal(err)
+        }
+        out := make([]byte, len(stream))
+        // Odd chunk sizes cross block boundaries
+        for i := 0; i < len(out); i += 7 {
+            end := i + 7
+            if end > len(out) {
+                end = len(out)
+            }
+            ctr.XORKeyStream(out[i:end], make([]byte, end-i))
+        }
+        if !bytes.Equal(out, stream) {
+            t.Errorf("CTR %d: wrong key stream", size)
+        }
+
+        // Seek to byte and block offsets
+        off := int64(2*bs + 3)
+        if n, err := ctr.Seek(off, io.SeekStart); n != off || err != nil {
+            t.Fatalf("CTR %d: Seek returned %d, %v", size, n, err)
+        }
+        part := make([]byte, bs)
+        ctr.XORKeyStream(part, part)
+        if !bytes.Equal(part, stream[off:off+int64(bs)]) {
+            t.Errorf("CTR %d: wrong key stream after Seek", size)
+        }
+        if n, _ := ctr.Seek(-int64(bs), io.SeekCurrent); n != off {
+            t.Errorf("CTR %d: Seek current returned %d", size, n)
+        }
+        ctr.SeekBlock(4)
+        ctr.XORKeyStream(part, make([]byte, bs))
+        if !bytes.Equal(part, stream[4*bs:]) {
+            t.Errorf("CTR %d: wrong key stream after SeekBlock", size)
+        }
+        ctr.SeekBlock(^uint64(0))
+        if _, err := ctr.Seek(0, io.SeekCurrent); err == nil {
+            t.Errorf("CTR %d: Seek accepted unrepresentable offset", size)
+        }
+        if _, err := ctr.Seek(-1, io.SeekStart); err == nil {
+            t.Errorf("CTR %d: Seek accepted negative offset", size)
+        }
+        if _, err := ctr.Seek(0, io.SeekEnd); err == nil {
+            t.Errorf("CTR %d: Seek accepted io.SeekEnd", size)
+        }
+        ctr.Seek(off, io.SeekStart)
+        if _, err := ctr.Seek(1<<63-1, io.SeekCurrent); err == nil {
+            t.Errorf("CTR %d: Seek accepted overflowing offset", size)
+        }
+
+        // The last block of the key stream, the counter must not wrap
+        ctr.SeekBlock(^uint64(0))
+        last := make([]byte, bs)
+        ctr.XORKeyStream(last[:3], last[:3])
+        ctr.XORKeyStream(last[3:], last[3:])
+        ref.SetTweak([]uint64{^uint64(0), binary.LittleEndian.Uint64(nonce)})
+        ref.Encrypt(part, zero)
+        if !bytes.Equal(last, part) {
+            t.Errorf("CTR %d: wrong key stream of the last block", size)
+        }
+        if ctr.Available(1) {
+            t.Errorf("CTR %d: Available after the end of the key stream", size)
+        }
+        if !panics(func() { ctr.XORKeyStream(part[:1], part[:1]) }) {
+            t.Errorf("CTR %d: counter wrapped", size)
+        }
+        if _, err := ctr.Seek(0, io.SeekCurrent); err == nil {
+            t.Errorf("CTR %d: Seek accepted the end of the key stream", size)
+        }
+        ctr.SeekBlock(^uint64(0) - 1)
+        if !ctr.Available(2*bs) || ctr.Available(2*bs+1) {
+            t.Errorf("CTR %d: Available wrong at the end of the key stream", size)
+        }
+        ctr.XORKeyStream(part[:1], part[:1])
+        if !ctr.Available(2*bs-1) || ctr.Available(2*bs) || !ctr.Available(0) {
+            t.Errorf("CTR %d: Available wrong after a partial block", size)
+        }
+        ctr.SeekBlock(^uint64(0))
+        last = make([]byte, bs+1)
+        if !panics(func() { ctr.XORKeyStream(last, last) }) {
+            t.Errorf("CTR %d: counter wrapped", size)
+        }
+        if !bytes.Equal(last, make([]byte, bs+1)) {
+            t.Errorf("CTR %d: XORKeyStream changed dst before it panicked", size)
+        }
+
+        // Decrypt in place
+        msg := []byte("Threefish counter mode with the tweak as counter")
+        ct := make([]byte, len(msg))
+        ctr.Seek(0, io.SeekStart)
+        ctr.XORKeyStream(ct, msg)
+        ctr.Seek(0, io.SeekStart)
+        ctr.XORKeyStream(ct, ct)
+        if !bytes.Equal(ct, msg) {
+            t.Errorf("CTR %d: decryption failed", size)
+        }
+    }
+    c, _ := NewSize(256)
+    if _, err := NewCTR(c, make([]byte, 16)); err == nil {
+        t.Error("NewCTR accepted wrong nonce size")
+    }
+}
+
+// Report if f panics.
+//
+func panics(f func()) (panicked bool) {
+    defer func() {
+        panicked = recover() != nil
+    }()
+    f()
+    return false
+}
@@ -0,0 +1,230 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+
+// This package implements a wide-block tweakable enciphering mode with
+// Threefish and Skein-MAC.
+//
+// The mode encrypts a message of any length of at least one Threefish
+// block without expansion, each ciphertext bit depends on all plaintext
+// bits and on the tweak. Thus the mode suits the encryption of file names,
+// of database records and the in-place encryption of pages. The tweak
+// takes the role of a nonce or of a position, for example the page number.
+//
+// The mode follows the hash-encrypt-hash structure of HCTR2. The message
+// is split into the first block L and the rest R:
+//
+//    MM = L xor H(T, R)
+//    UU = E(MM)
+//    S  = MM xor UU
+//    V  = R xor CTR(S)
+//    U  = UU xor H(T, V)
+//
+// The ciphertext is U || V. H is the Skein-MAC, with the Threefish state
+// size as output size, of the tweak length as little endian uint64, the
+// tweak and the data. E is Threefish with the tweak words {0, 1}. Key
+// stream block i of CTR is the Threefish encryption of S with the tweak
+// words {i, 2}. E keys Threefish with the first half, H the MAC with the
+// second half of DeriveKey(key, "crypto/threefish/wide").
+//
+// NOTE: the mode is deterministic, equal messages with equal tweaks give
+// equal ciphertexts. It does not authenticate the message.
+//
+package wide
+
+import (
+    "crypto/skein"
+    "crypto/subtle"
+    "crypto/threefish"
+    "encoding/binary"
+    "strconv"
+    "sync"
+)
+
+// Minimum size of the key in bytes
+const MinKeySize = 16
+
+// Threefish tweak domains
+const (
+    domainBlock  = 1
+    domainStream = 2
+)
+
+// The key identifier for the derivation of the Threefish and MAC keys
+var kdfKeyID = []byte("crypto/threefish/wide")
+
+// A Cipher is an instance of the wide-block mode using a particular key
+// and state size.
+//
+// All functions are safe for concurrent use by several goroutines.
+//
+type Cipher struct {
+    mu     sync.Mutex
+    cipher *threefish.Cipher
+    mac    *skein.SkeinMac
+    tweak  [2]uint64
+    mm     []byte
+    uu     []byte
+    s      []byte
+    block  []byte
+}
+
+type KeySizeError int
+
+func (k KeySizeError) Error() string {
+    return "crypto/threefish/wide: invalid key size " + strconv.Itoa(int(k))
+}
+
+type stateSizeError int
+
+func (s stateSizeError) Error() string {
+    return "crypto/threefish/wide: invalid state size " + strconv.Itoa(int(s))
+}
+
+// New creates and returns a wide-block Cipher.
+//
+// key
+//      The key, at least MinKeySize bytes
+// stateSize
+//      The Threefish and Skein state size in bits. Supported values
+//      are 256, 512, and 1024. The minimum message length is the
+//      Threefish block size.
+//
+func New(key []byte, stateSize int) (*Cipher, error) {
+    if len(key) < MinKeySize {
+        return nil, KeySizeError(len(key))
+    }
+    if stateSize != skein.Skein256 && stateSize != skein.Skein512 && stateSize != skein.Skein1024 {
+        return nil, stateSizeError(stateSize)
+    }
+    bs := stateSize / 8
+    keys, err := skein.DeriveKey(key, kdfKeyID, 2*bs)
+    if err != nil {
+        return nil, err
+    }
+    c := new(Cipher)
+    if c.cipher, err = threefish.New(keys[:bs], nil); err != nil {
+        return nil, err
+    }
+    if c.mac, err = skein.NewMac(stateSize, stateSize, keys[bs:]); err != nil {
+        return nil, err
+    }
+    clear(keys)
+    c.mm = make([]byte, bs)
+    c.uu = make([]byte, bs)
+    c.s = make([]byte, bs)
+    c.block = make([]byte, bs)
+    return c, nil
+}
+
+// BlockSize returns the Threefish block size in bytes, the minimum
+// message length.
+//
+func (c *Cipher) BlockSize() int {
+    return len(c.block)
+}
+
+func (c *Cipher) check(dst, src []byte) {
+    if len(src) < len(c.block) {
+        panic("crypto/threefish/wide: input shorter than one block")
+    }
+    if len(dst) < len(src) {
+        panic("crypto/threefish/wide: output smaller than input")
+    }
+}
+
+// XOR data with H(tweak, text).
+//
+func (c *Cipher) hash(data, tweak, text []byte) {
+    var length [8]byte
+    binary.LittleEndian.PutUint64(length[:], uint64(len(tweak)))
+    c.mac.Update(length[:])
+    c.mac.Update(tweak)
+    c.mac.Update(text)
+    subtle.XORBytes(data, data, c.mac.DoFinal())
+}
+
+func (c *Cipher) setTweak(index, domain uint64) {
+    c.tweak[0] = index
+    c.tweak[1] = domain
+    c.cipher.SetTweak(c.tweak[:])
+}
+
+// XOR dst with the key stream of CTR(S), S is c.mm xor c.uu.
+//
+func (c *Cipher) xorKeyStream(dst, src []byte) {
+    subtle.XORBytes(c.s, c.mm, c.uu)
+    for i := uint64(0); len(src) > 0; i++ {
+        c.setTweak(i, domainStream)
+        c.cipher.Encrypt(c.block, c.s)
+        n := subtle.XORBytes(dst, src, c.block)
+        dst = dst[n:]
+        src = src[n:]
+    }
+}
+
+// Encrypt a message.
+// Dst and src may point at the same memory.
+//
+// dst
+//      Destination of encrypted data (cipher data), at least as long as src
+// src
+//      Contains the plain data, at least one block
+// tweak
+//      The tweak, any length
+//
+func (c *Cipher) Encrypt(dst, src, tweak []byte) {
+    c.check(dst, src)
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    bs := len(c.block)
+    n := len(src)
+    copy(c.mm, src[:bs])
+    c.hash(c.mm, tweak, src[bs:])
+    c.setTweak(0, domainBlock)
+    c.cipher.Encrypt(c.uu, c.mm)
+    c.xorKeyStream(dst[bs:n], src[bs:])
+    copy(dst, c.uu)
+    c.hash(dst[:bs], tweak, dst[bs:n])
+}
+
+// Decrypt a message.
+// Dst and src may point at the same memory.
+//
+// dst
+//      Destination of decrypted data (plain data), at least as long as src
+// src
+//      Contains the encrypted data, at least one block
+// tweak
+//      The tweak used to encrypt the message
+//
+func (c *Cipher) Decrypt(dst, src, tweak []byte) {
+    c.check(dst, src)
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    bs := len(c.block)
+    n := len(src)
+    copy(c.uu, src[:bs])
+    c.hash(c.uu, tweak, src[bs:])
+    c.setTweak(0, domainBlock)
+    c.cipher.Decrypt(c.mm, c.uu)
+    c.xorKeyStream(dst[bs:n], src[bs:])
+    copy(dst, c.mm)
+    c.hash(dst[:bs], tweak, dst[bs:n])
+}
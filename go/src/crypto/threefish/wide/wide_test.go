@@ -0,0 +1,208 @@
+// Copyright (C) 2011 Werner Dittmann
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// Authors: Werner Dittmann <Werner.Dittmann@t-online.de>
+//
+package wide
+
+import (
+	"bytes"
+	"crypto/skein"
+	"crypto/subtle"
+	"crypto/threefish"
+	"encoding/binary"
+	"testing"
+)
+
+func testData() (key, pt, tweak []byte) {
+	key = make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	pt = make([]byte, 5000)
+	for i := range pt {
+		pt[i] = byte(i * 3)
+	}
+	tweak = make([]byte, 100)
+	for i := range tweak {
+		tweak[i] = byte(0xff - i)
+	}
+	return
+}
+
+// Encrypt a message as described in the package doc, from Threefish and
+// Skein-MAC.
+func refEncrypt(key []byte, stateSize int, pt, tweak []byte) []byte {
+	bs := stateSize / 8
+	keys, _ := skein.DeriveKey(key, []byte("crypto/threefish/wide"), 2*bs)
+	h := func(data, text []byte) {
+		mac, _ := skein.NewMac(stateSize, stateSize, keys[bs:])
+		var length [8]byte
+		binary.LittleEndian.PutUint64(length[:], uint64(len(tweak)))
+		mac.Update(length[:])
+		mac.Update(tweak)
+		mac.Update(text)
+		subtle.XORBytes(data, data, mac.DoFinal())
+	}
+	c, _ := threefish.New(keys[:bs], nil)
+	mm := append([]byte(nil), pt[:bs]...)
+	h(mm, pt[bs:])
+	uu := make([]byte, bs)
+	c.SetTweak([]uint64{0, 1})
+	c.Encrypt(uu, mm)
+	s := make([]byte, bs)
+	subtle.XORBytes(s, mm, uu)
+	ct := make([]byte, len(pt))
+	block := make([]byte, bs)
+	for i := bs; i < len(pt); i += bs {
+		c.SetTweak([]uint64{uint64(i/bs - 1), 2})
+		c.Encrypt(block, s)
+		subtle.XORBytes(ct[i:], pt[i:], block)
+	}
+	copy(ct, uu)
+	h(ct[:bs], ct[bs:])
+	return ct
+}
+
+func TestConstruction(t *testing.T) {
+	key, pt, tweak := testData()
+	for _, size := range []int{256, 512, 1024} {
+		c, err := New(key, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs := c.BlockSize()
+		if bs != size/8 {
+			t.Errorf("Wide-%d: wrong block size %d", size, bs)
+		}
+		for _, n := range []int{bs, bs + 1, 2*bs - 1, 2 * bs, 4096} {
+			for _, m := range []int{0, 7, 100} {
+				ct := make([]byte, n)
+				c.Encrypt(ct, pt[:n], tweak[:m])
+				if expected := refEncrypt(key, size, pt[:n], tweak[:m]); !bytes.Equal(ct, expected) {
+					t.Errorf("Wide-%d %d/%d: got %x, expected %x", size, n, m, ct, expected)
+				}
+				// In place
+				c.Decrypt(ct, ct, tweak[:m])
+				if !bytes.Equal(ct, pt[:n]) {
+					t.Errorf("Wide-%d %d/%d: decryption failed", size, n, m)
+				}
+			}
+		}
+	}
+}
+
+func TestMinimumLength(t *testing.T) {
+	key, pt, tweak := testData()
+	for _, size := range []int{256, 512, 1024} {
+		c, _ := New(key, size)
+		bs := c.BlockSize()
+		// A single block is a tweaked Threefish encryption, the hashes of
+		// the empty rest still depend on the tweak
+		ct := make([]byte, bs)
+		c.Encrypt(ct, pt[:bs], tweak)
+		other := make([]byte, bs)
+		c.Encrypt(other, pt[:bs], nil)
+		if bytes.Equal(ct, other) {
+			t.Errorf("Wide-%d: the tweak does not change a single block", size)
+		}
+		c.Decrypt(ct, ct, tweak)
+		if !bytes.Equal(ct, pt[:bs]) {
+			t.Errorf("Wide-%d: single block decryption failed", size)
+		}
+		// One byte less is too short, for Encrypt and Decrypt
+		for _, f := range []func([]byte, []byte, []byte){c.Encrypt, c.Decrypt} {
+			if !panics(func() { f(make([]byte, bs), pt[:bs-1], nil) }) {
+				t.Errorf("Wide-%d: accepted a message of %d bytes", size, bs-1)
+			}
+			if !panics(func() { f(make([]byte, bs), pt[:bs+1], nil) }) {
+				t.Errorf("Wide-%d: accepted a short destination", size)
+			}
+		}
+	}
+}
+
+// Count the differing bytes of two slices.
+func diff(a, b []byte) int {
+	n := 0
+	for i := range a {
+		if a[i] != b[i] {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTweakSensitivity(t *testing.T) {
+	key, pt, tweak := testData()
+	for _, size := range []int{256, 512, 1024} {
+		c, _ := New(key, size)
+		n := 3*c.BlockSize() + 5
+		ct := make([]byte, n)
+		c.Encrypt(ct, pt[:n], tweak)
+		other := make([]byte, n)
+
+		// A flipped tweak bit or a shorter tweak change the whole
+		// ciphertext, the length prefix separates an empty tweak from a
+		// zero tweak
+		mod := append([]byte(nil), tweak...)
+		mod[50] ^= 0x80
+		for _, tw := range [][]byte{mod, tweak[:99], tweak[:1]} {
+			c.Encrypt(other, pt[:n], tw)
+			if d := diff(ct, other); d < n-n/32-1 {
+				t.Errorf("Wide-%d: tweak change affects %d bytes", size, d)
+			}
+		}
+		var zeros [8]byte
+		c.Encrypt(ct, pt[:n], nil)
+		c.Encrypt(other, pt[:n], zeros[:])
+		if d := diff(ct, other); d < n-n/32-1 {
+			t.Errorf("Wide-%d: empty and zero tweak differ in %d bytes", size, d)
+		}
+
+		// Decryption with another tweak scrambles the whole plaintext
+		c.Encrypt(ct, pt[:n], tweak)
+		c.Decrypt(other, ct, mod)
+		if d := diff(other, pt[:n]); d < n-n/32-1 {
+			t.Errorf("Wide-%d: wrong tweak affects %d plaintext bytes", size, d)
+		}
+		// A change of the last plaintext byte changes the whole
+		// ciphertext, also the first block
+		copy(other, pt[:n])
+		other[n-1] ^= 1
+		c.Encrypt(other, other, tweak)
+		if d := diff(ct, other); d < n-n/32-1 {
+			t.Errorf("Wide-%d: plaintext change affects %d bytes", size, d)
+		}
+	}
+}
+
+// Report if f panics.
+func panics(f func()) (panicked bool) {
+	defer func() {
+		panicked = recover() != nil
+	}()
+	f()
+	return false
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(make([]byte, MinKeySize-1), 512); err == nil {
+		t.Error("New accepted short key")
+	}
+	if _, err := New(make([]byte, 32), 384); err == nil {
+		t.Error("New accepted invalid state size")
+	}
+}
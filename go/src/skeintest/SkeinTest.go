@@ -30,7 +30,25 @@ func checkKATVectors(ks *katScanner) bool {
     var tree, mac, normal int
 
     for  ks.fillResult(kr) {
-        if strings.Contains(string(kr.restOfLine), "Tree") {
+        if idx := strings.Index(string(kr.restOfLine), "Tree:"); idx >= 0 {
+            var leaf, node, maxLevel int
+            fmt.Sscanf(string(kr.restOfLine[idx:]), "Tree: leaf=%x, node=%x, maxLevels=%x",
+                &leaf, &node, &maxLevel)
+            skein, err := skein.NewExtended(kr.stateSize, kr.hashBitLength,
+                skein.TreeInfo(leaf, node, maxLevel), nil)
+            if err != nil {
+                fmt.Printf("Cannot create tree hash: %s\n", err)
+                return false
+            }
+            skein.UpdateBits(kr.msg, kr.msgLength)
+            hash := skein.DoFinal()
+            if ret := bytes.Compare(hash, kr.result); ret != 0 {
+                fmt.Printf("%d-%d-%d-%s\n", kr.stateSize, kr.hashBitLength,
+                    kr.msgLength, string(kr.restOfLine))
+                fmt.Printf("Computed tree hash:\n%s\n", hex.EncodeToString(hash))
+                fmt.Printf("Expected result:\n%s\n", hex.EncodeToString(kr.result))
+                return false
+            }
             tree++
             continue
         }
@@ -82,8 +100,8 @@ func checkKATVectors(ks *katScanner) bool {
         }
         normal++
     }
-    fmt.Printf("(Tree: %d), mac: %d, normal: %d, Skein tests total: %d\n", 
-        tree, mac, normal, mac+normal)
+    fmt.Printf("Tree: %d, mac: %d, normal: %d, Skein tests total: %d\n", 
+        tree, mac, normal, tree+mac+normal)
     return true
 }
 
@@ -253,7 +271,7 @@ func (s *katScanner) parseResultLine(line string, kr *katResult) {
         kr.resultFill++
     }
     if err != nil && ret <= 0 {
-        fmt.Printf("result: %s, ret: %d, %s \n", hex.EncodeToString(kr.result))
+        fmt.Printf("result: %s, ret: %d, %s \n", hex.EncodeToString(kr.result), ret, err)
     }
 }
 